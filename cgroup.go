@@ -0,0 +1,449 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type cgroupCollector struct {
+	opts     *options
+	values   []cgroupValue
+	names    []string
+	counters *counterSet
+}
+
+// A cgroupValue is a stat of a cgroup.
+type cgroupValue struct {
+	cgroup string
+	name   string
+	value  uint64
+	kind   Kind
+	unit   Unit
+	source string
+}
+
+const (
+	cgroupUnifiedRoot = "unified"
+	cgroupControllers = "cgroup.controllers"
+)
+
+// NewCgroupStat returns a new Stat, which collects CPU, memory and I/O stats
+// of the cgroups selected with WithCgroup. The stats are read from cpu.stat,
+// memory.current, memory.stat, memory.events and io.stat in the cgroup v2
+// hierarchy, or from the corresponding files of the cpu, cpuacct, memory and
+// blkio controllers on hosts that only have the cgroup v1 hierarchy. The
+// cgroups are selected again on every sample, so removed cgroups are dropped
+// and new matches are picked up during a recording. An error is returned if
+// no cgroups with stats are selected.
+func NewCgroupStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	reader := &cgroupCollector{opts: o}
+	if len(o.cgroups) == 0 {
+		return nil, fmt.Errorf("no cgroups selected")
+	}
+	values, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s: no cgroups with stats match %s", o.sysPath(cgroupRoot), strings.Join(o.cgroups, ","))
+	}
+	names, counters := parseCgroupCounters(values)
+	reader.values = values
+	reader.names = parseCgroupNames(values)
+	reader.counters = newCounterSet(names, counters, o)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+func (reader *cgroupCollector) Collect() ([]float64, error) {
+	values, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	reader.values = values
+	names, counters := parseCgroupCounters(values)
+	diff := reader.counters.update(reader.names, names, counters)
+	current := map[string]cgroupValue{}
+	for _, value := range values {
+		current[value.name] = value
+	}
+	var result []float64
+	for idx, name := range reader.names {
+		value, ok := current[name]
+		switch {
+		case !ok:
+			result = append(result, math.NaN())
+		case value.kind == Gauge:
+			result = append(result, float64(value.value))
+		default:
+			result = append(result, diff[idx])
+		}
+	}
+	return result, nil
+}
+
+// UpdateColumns updates stat to match the cgroups in the last sample.
+func (reader *cgroupCollector) UpdateColumns(stat *Stat) bool {
+	names := parseCgroupNames(reader.values)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.describe(stat)
+	return true
+}
+
+func (reader *cgroupCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	stat.Sources = nil
+	for _, value := range reader.values {
+		unit := value.unit
+		description := string(unit)
+		if value.kind == Counter {
+			description = reader.opts.unit(description)
+			unit = reader.opts.counterUnit(unit)
+		}
+		key := strings.TrimPrefix(value.name, cgroupStatName(value.cgroup, ""))
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s %s (%s)", value.name, value.cgroup, key, description))
+		stat.Kinds = append(stat.Kinds, value.kind)
+		stat.Units = append(stat.Units, unit)
+		stat.Sources = append(stat.Sources, value.source)
+	}
+}
+
+// read reads the stats of the selected cgroups. Cgroups that are removed
+// while they are being read are skipped.
+func (reader *cgroupCollector) read() ([]cgroupValue, error) {
+	v2Root := reader.opts.sysPath(cgroupRoot)
+	v2 := isCgroupV2(v2Root)
+	root := v2Root
+	if !v2 {
+		root = filepath.Join(v2Root, "memory")
+	}
+	var values []cgroupValue
+	for _, cgroup := range selectCgroups(root, reader.opts.cgroups) {
+		if v2 {
+			values = append(values, readCgroupV2(reader.opts, v2Root, cgroup)...)
+		} else {
+			values = append(values, readCgroupV1(reader.opts, v2Root, cgroup)...)
+		}
+	}
+	return values, nil
+}
+
+// isCgroupV2 returns true if the cgroup v2 hierarchy is mounted at root. On
+// hosts with both hierarchies, the v2 hierarchy is mounted under "unified"
+// and the controllers are in the v1 hierarchy.
+func isCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, cgroupControllers))
+	return err == nil
+}
+
+// cgroupV2Root returns the directory where the cgroup v2 hierarchy is
+// mounted, which is used for files that only exist in the v2 hierarchy,
+// such as pressure files.
+func cgroupV2Root(o *options) string {
+	root := o.sysPath(cgroupRoot)
+	if isCgroupV2(root) {
+		return root
+	}
+	unified := filepath.Join(root, cgroupUnifiedRoot)
+	if isCgroupV2(unified) {
+		return unified
+	}
+	return root
+}
+
+// selectCgroups returns the paths, relative to root, of the cgroups that
+// match one of the glob patterns, in lexical order.
+func selectCgroups(root string, patterns []string) []string {
+	selected := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(root, pattern))
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			cgroup, err := filepath.Rel(root, match)
+			if err != nil {
+				continue
+			}
+			selected["/"+strings.TrimPrefix(filepath.ToSlash(cgroup), ".")] = true
+		}
+	}
+	var cgroups []string
+	for cgroup := range selected {
+		cgroups = append(cgroups, filepath.Clean(cgroup))
+	}
+	sort.Strings(cgroups)
+	return cgroups
+}
+
+// cgroupName returns the name of a cgroup in column names, which is the
+// path of the cgroup without the leading slash, or "root" for the root
+// cgroup. Whitespace and commas, which would split the column name in
+// recorded stats, are replaced with underscores.
+func cgroupName(cgroup string) string {
+	name := strings.TrimPrefix(cgroup, "/")
+	if name == "" {
+		return "root"
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ',' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// cgroupMemoryCounters are the prefixes of memory.stat keys that are event
+// counters rather than amounts of memory. In the cgroup v1 hierarchy,
+// memory.stat also has the totals of each key over the cgroup and its
+// descendants, such as "total_pgfault", which are classified like the key.
+var cgroupMemoryCounters = []string{
+	"pg",
+	"workingset_refault",
+	"workingset_activate",
+	"workingset_restore",
+	"workingset_nodereclaim",
+	"thp_",
+	"zswpin",
+	"zswpout",
+	"zswpwb",
+}
+
+func cgroupMemoryStat(cgroup string, path string, stat keyValue) cgroupValue {
+	value := cgroupValue{
+		cgroup: cgroup,
+		name:   cgroupStatName(cgroup, "memory.stat."+stat.key),
+		value:  stat.value,
+		kind:   Gauge,
+		unit:   Bytes,
+		source: path,
+	}
+	key := strings.TrimPrefix(stat.key, "total_")
+	for _, counter := range cgroupMemoryCounters {
+		if strings.HasPrefix(key, counter) {
+			value.kind = Counter
+			value.unit = Count
+		}
+	}
+	return value
+}
+
+func cgroupStatName(cgroup string, key string) string {
+	return fmt.Sprintf("cgroup.%s.%s", cgroupName(cgroup), key)
+}
+
+func readCgroupV2(o *options, root string, cgroup string) []cgroupValue {
+	dir := filepath.Join(root, cgroup)
+	var values []cgroupValue
+	path := filepath.Join(dir, "cpu.stat")
+	if stats, err := readKeyValues(path); err == nil {
+		for _, stat := range stats {
+			unit := Count
+			if strings.HasSuffix(stat.key, "_usec") {
+				unit = Microseconds
+			}
+			values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "cpu."+stat.key), stat.value, Counter, unit, path})
+		}
+	}
+	path = filepath.Join(dir, "memory.current")
+	if value, err := readUint(path); err == nil {
+		values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "memory.current"), value, Gauge, Bytes, path})
+	}
+	path = filepath.Join(dir, "memory.stat")
+	if stats, err := readKeyValues(path); err == nil {
+		for _, stat := range stats {
+			values = append(values, cgroupMemoryStat(cgroup, path, stat))
+		}
+	}
+	path = filepath.Join(dir, "memory.events")
+	if stats, err := readKeyValues(path); err == nil {
+		for _, stat := range stats {
+			values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "memory.events."+stat.key), stat.value, Counter, Count, path})
+		}
+	}
+	path = filepath.Join(dir, "io.stat")
+	if stats, err := readCgroupIOStat(o, path); err == nil {
+		for _, stat := range stats {
+			unit := Count
+			if strings.HasSuffix(stat.key, "bytes") {
+				unit = Bytes
+			}
+			values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "io."+stat.key), stat.value, Counter, unit, path})
+		}
+	}
+	return values
+}
+
+// readCgroupV1 reads the stats of a cgroup from the cgroup v1 controllers and
+// names them like the corresponding cgroup v2 stats.
+func readCgroupV1(o *options, root string, cgroup string) []cgroupValue {
+	var values []cgroupValue
+	path := filepath.Join(root, "cpuacct", cgroup, "cpuacct.usage")
+	if value, err := readUint(path); err == nil {
+		values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "cpu.usage_usec"), value / 1000, Counter, Microseconds, path})
+	}
+	path = filepath.Join(root, "cpuacct", cgroup, "cpuacct.stat")
+	if stats, err := readKeyValues(path); err == nil {
+		for _, stat := range stats {
+			name := cgroupStatName(cgroup, fmt.Sprintf("cpu.%s_usec", stat.key))
+			values = append(values, cgroupValue{cgroup, name, stat.value * 1000000 / clockTicks, Counter, Microseconds, path})
+		}
+	}
+	path = filepath.Join(root, "cpu", cgroup, "cpu.stat")
+	if stats, err := readKeyValues(path); err == nil {
+		for _, stat := range stats {
+			if strings.HasSuffix(stat.key, "_time") {
+				key := strings.TrimSuffix(stat.key, "_time") + "_usec"
+				values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "cpu."+key), stat.value / 1000, Counter, Microseconds, path})
+				continue
+			}
+			values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "cpu."+stat.key), stat.value, Counter, Count, path})
+		}
+	}
+	path = filepath.Join(root, "memory", cgroup, "memory.usage_in_bytes")
+	if value, err := readUint(path); err == nil {
+		values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "memory.current"), value, Gauge, Bytes, path})
+	}
+	path = filepath.Join(root, "memory", cgroup, "memory.stat")
+	if stats, err := readKeyValues(path); err == nil {
+		for _, stat := range stats {
+			values = append(values, cgroupMemoryStat(cgroup, path, stat))
+		}
+	}
+	blkioFiles := []struct {
+		name   string
+		suffix string
+		unit   Unit
+	}{
+		{"blkio.throttle.io_service_bytes", "bytes", Bytes},
+		{"blkio.throttle.io_serviced", "ios", Count},
+	}
+	for _, blkioFile := range blkioFiles {
+		path = filepath.Join(root, "blkio", cgroup, blkioFile.name)
+		stats, err := readCgroupBlkioStat(o, path, blkioFile.suffix)
+		if err != nil {
+			continue
+		}
+		for _, stat := range stats {
+			values = append(values, cgroupValue{cgroup, cgroupStatName(cgroup, "io."+stat.key), stat.value, Counter, blkioFile.unit, path})
+		}
+	}
+	return values
+}
+
+// readCgroupIOStat reads a cgroup v2 io.stat file, which has a device number
+// followed by key=value pairs on each line, such as:
+//
+//	8:0 rbytes=90112 wbytes=0 rios=4 wios=0 dbytes=0 dios=0
+//
+// The keys are the device name and the key, such as "sda.rbytes".
+func readCgroupIOStat(o *options, path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		device := blockDeviceName(o, fields[0])
+		for _, field := range fields[1:] {
+			idx := strings.Index(field, "=")
+			if idx < 0 {
+				continue
+			}
+			value, err := strconv.ParseUint(field[idx+1:], 10, 64)
+			if err != nil {
+				continue
+			}
+			values = append(values, keyValue{key: fmt.Sprintf("%s.%s", device, field[:idx]), value: value})
+		}
+	}
+	return values, nil
+}
+
+// readCgroupBlkioStat reads a cgroup v1 blkio file, which has a device number,
+// an operation and a value on each line, such as "8:0 Read 90112". The keys
+// are named like the keys in cgroup v2 io.stat, such as "sda.rbytes".
+func readCgroupBlkioStat(o *options, path string, suffix string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	operations := map[string]string{"Read": "r", "Write": "w", "Discard": "d"}
+	var values []keyValue
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		operation, ok := operations[fields[1]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		key := fmt.Sprintf("%s.%s%s", blockDeviceName(o, fields[0]), operation, suffix)
+		values = append(values, keyValue{key: key, value: value})
+	}
+	return values, nil
+}
+
+// blockDeviceName returns the name of the block device with the device
+// number "major:minor", or the device number if the device is not found in
+// /sys/dev/block.
+func blockDeviceName(o *options, device string) string {
+	target, err := os.Readlink(o.sysPath(filepath.Join("dev", "block", device)))
+	if err != nil {
+		return device
+	}
+	return filepath.Base(target)
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func parseCgroupNames(values []cgroupValue) []string {
+	var names []string
+	for _, value := range values {
+		names = append(names, value.name)
+	}
+	return names
+}
+
+func parseCgroupCounters(values []cgroupValue) ([]string, []uint64) {
+	var names []string
+	var counters []uint64
+	for _, value := range values {
+		if value.kind == Counter {
+			names = append(names, value.name)
+			counters = append(counters, value.value)
+		}
+	}
+	return names, counters
+}
@@ -0,0 +1,118 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A cgroupColumn is the expected kind, unit and value of a cgroup column.
+type cgroupColumn struct {
+	kind  Kind
+	unit  Unit
+	value float64
+}
+
+func TestCgroupV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "fs", "cgroup")
+	writeFile(t, filepath.Join(root, "cgroup.controllers"), "cpu memory io\n")
+	job := filepath.Join(root, "system.slice", "job.service")
+	writeFile(t, filepath.Join(job, "cpu.stat"), "usage_usec 1000\nnr_throttled 0\n")
+	writeFile(t, filepath.Join(job, "memory.current"), "4096\n")
+	writeFile(t, filepath.Join(job, "memory.stat"), "anon 1024\npgfault 10\n")
+	writeFile(t, filepath.Join(job, "io.stat"), "8:0 rbytes=100 rios=1\n")
+	if err := os.MkdirAll(filepath.Join(dir, "dev", "block"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../devices/virtual/block/sda", filepath.Join(dir, "dev", "block", "8:0")); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := NewCgroupStat(WithSysRoot(dir), WithCgroup("/system.slice/*.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(job, "cpu.stat"), "usage_usec 1500\nnr_throttled 1\n")
+	writeFile(t, filepath.Join(job, "memory.current"), "8192\n")
+	writeFile(t, filepath.Join(job, "memory.stat"), "anon 2048\npgfault 15\n")
+	writeFile(t, filepath.Join(job, "io.stat"), "8:0 rbytes=300 rios=3\n")
+	checkCgroupStat(t, stat, map[string]cgroupColumn{
+		"cgroup.system.slice/job.service.cpu.usage_usec":      {Counter, Microseconds, 500},
+		"cgroup.system.slice/job.service.cpu.nr_throttled":    {Counter, Count, 1},
+		"cgroup.system.slice/job.service.memory.current":      {Gauge, Bytes, 8192},
+		"cgroup.system.slice/job.service.memory.stat.anon":    {Gauge, Bytes, 2048},
+		"cgroup.system.slice/job.service.memory.stat.pgfault": {Counter, Count, 5},
+		"cgroup.system.slice/job.service.io.sda.rbytes":       {Counter, Bytes, 200},
+		"cgroup.system.slice/job.service.io.sda.rios":         {Counter, Count, 2},
+	})
+}
+
+func TestCgroupV1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "fs", "cgroup")
+	writeFile(t, filepath.Join(root, "cpuacct", "job", "cpuacct.usage"), "5000000\n")
+	memory := filepath.Join(root, "memory", "job")
+	writeFile(t, filepath.Join(memory, "memory.usage_in_bytes"), "4096\n")
+	writeFile(t, filepath.Join(memory, "memory.stat"), "cache 4096\npgfault 10\ntotal_cache 8192\ntotal_pgfault 20\ntotal_pgmajfault 1\n")
+	stat, err := NewCgroupStat(WithSysRoot(dir), WithCgroup("/job"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "cpuacct", "job", "cpuacct.usage"), "7000000\n")
+	writeFile(t, filepath.Join(memory, "memory.stat"), "cache 4096\npgfault 12\ntotal_cache 8192\ntotal_pgfault 25\ntotal_pgmajfault 1\n")
+	checkCgroupStat(t, stat, map[string]cgroupColumn{
+		"cgroup.job.cpu.usage_usec":               {Counter, Microseconds, 2000},
+		"cgroup.job.memory.current":               {Gauge, Bytes, 4096},
+		"cgroup.job.memory.stat.cache":            {Gauge, Bytes, 4096},
+		"cgroup.job.memory.stat.pgfault":          {Counter, Count, 2},
+		"cgroup.job.memory.stat.total_cache":      {Gauge, Bytes, 8192},
+		"cgroup.job.memory.stat.total_pgfault":    {Counter, Count, 5},
+		"cgroup.job.memory.stat.total_pgmajfault": {Counter, Count, 0},
+	})
+}
+
+func TestCgroupNoMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "fs", "cgroup", "cgroup.controllers"), "cpu memory io\n")
+	if _, err := NewCgroupStat(WithSysRoot(dir), WithCgroup("/missing*")); err == nil {
+		t.Error("NewCgroupStat() succeeded without matching cgroups, want error")
+	}
+}
+
+// checkCgroupStat checks that stat has the expected columns and that the
+// next sample has the expected values.
+func checkCgroupStat(t *testing.T, stat *Stat, want map[string]cgroupColumn) {
+	if len(stat.Names) != len(want) {
+		t.Fatalf("Names = %v, want %d columns", stat.Names, len(want))
+	}
+	if len(stat.Descriptions) != len(stat.Names) || len(stat.Kinds) != len(stat.Names) || len(stat.Units) != len(stat.Names) || len(stat.Sources) != len(stat.Names) {
+		t.Fatalf("len(Descriptions), len(Kinds), len(Units), len(Sources) = %d, %d, %d, %d, want %d", len(stat.Descriptions), len(stat.Kinds), len(stat.Units), len(stat.Sources), len(stat.Names))
+	}
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, name := range stat.Names {
+		column, ok := want[name]
+		if !ok {
+			t.Errorf("unexpected column %s", name)
+			continue
+		}
+		if stat.Kinds[idx] != column.kind || stat.Units[idx] != column.unit || values[idx] != column.value {
+			t.Errorf("%s: kind, unit, value = %v, %v, %v, want %v, %v, %v", name, stat.Kinds[idx], stat.Units[idx], values[idx], column.kind, column.unit, column.value)
+		}
+	}
+}
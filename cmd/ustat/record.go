@@ -1,28 +1,72 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/penberg/ustat"
 	"gopkg.in/urfave/cli.v1"
+	"io/ioutil"
 	"os"
-	"os/signal"
+	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
-	"syscall"
+	"strings"
 	"time"
 )
 
-const defaultDelay = 1
+const defaultDelay = time.Second
+
+// Error policies, which determine what happens when a collector fails.
+const (
+	errorPolicySkip    = "skip"
+	errorPolicyDisable = "disable"
+	errorPolicyAbort   = "abort"
+)
+
+// A collector is a stats collector that can be enabled on the command line.
+// Collectors that are enabled by default are recorded if no collector is
+// enabled explicitly; the others are only recorded if they are enabled.
+type collector struct {
+	name      string
+	newStat   func(...ustat.Option) (*ustat.Stat, error)
+	byDefault bool
+}
+
+var collectors = []collector{
+	{"cpu", ustat.NewCPUsStat, true},
+	{"cpufreq", ustat.NewCPUFreqStat, false},
+	{"int", ustat.NewInterruptsStat, true},
+	{"softirq", ustat.NewSoftIRQsStat, true},
+	{"net", ustat.NewNetStat, true},
+	{"disk", ustat.NewDiskStat, true},
+	{"mem", ustat.NewMemInfoStat, false},
+	{"vmstat", ustat.NewVMStat, false},
+	{"numa", ustat.NewNUMAStat, false},
+	{"load", ustat.NewLoadAvgStat, false},
+	{"thermal", ustat.NewThermalStat, false},
+	{"psi", ustat.NewPSIStat, false},
+	{"snmp", ustat.NewSNMPStat, false},
+	{"sockstat", ustat.NewSockStatStat, false},
+	{"proc", ustat.NewProcessStat, false},
+	{"schedstat", ustat.NewSchedStat, false},
+	{"cgroup", ustat.NewCgroupStat, false},
+}
 
 var recordCommand = cli.Command{
-	Name:      "record",
-	Usage:     "record system stats",
-	ArgsUsage: "[delay]",
+	Name:        "record",
+	Usage:       "record system stats",
+	ArgsUsage:   "[delay]",
+	Description: "Records system stats every delay, which is a duration such as '100ms' or '2.5s', or a number\n   of seconds such as '0.5'. The default delay is one second.\n\n   If a command is given after '--', records stats for as long as the command runs and exits\n   with the exit status of the command.",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "c,cpu",
 			Usage: "enable CPU stats collection",
 		},
+		cli.BoolFlag{
+			Name:  "cpufreq",
+			Usage: "enable CPU frequency and idle state stats collection",
+		},
 		cli.BoolFlag{
 			Name:  "i,int",
 			Usage: "enable interrupt stats collection",
@@ -39,10 +83,110 @@ var recordCommand = cli.Command{
 			Name:  "d,disk",
 			Usage: "enable disk stats collection",
 		},
+		cli.StringFlag{
+			Name:  "net-include",
+			Usage: "collect stats only for network interfaces matching one of the comma-separated `GLOBS` such as 'eth*,ens*'",
+		},
+		cli.StringFlag{
+			Name:  "net-exclude",
+			Usage: "skip network interfaces matching one of the comma-separated `GLOBS` such as 'lo,veth*,cali*'",
+		},
+		cli.StringFlag{
+			Name:  "disk-include",
+			Usage: "collect stats only for disks matching one of the comma-separated `GLOBS` such as 'sd*,nvme*'",
+		},
+		cli.StringFlag{
+			Name:  "disk-exclude",
+			Usage: "skip disks matching one of the comma-separated `GLOBS` such as 'loop*,ram*'",
+		},
+		cli.BoolFlag{
+			Name:  "whole-disks",
+			Usage: "skip disk partitions",
+		},
+		cli.BoolFlag{
+			Name:  "dm-names",
+			Usage: "report device-mapper devices by their names instead of as dm-N",
+		},
 		cli.StringFlag{
 			Name:  "o,output",
 			Usage: "write output to `FILE`",
 		},
+		cli.BoolFlag{
+			Name:  "m,mem",
+			Usage: "enable memory stats collection",
+		},
+		cli.BoolFlag{
+			Name:  "vmstat",
+			Usage: "enable virtual memory stats collection",
+		},
+		cli.BoolFlag{
+			Name:  "numa",
+			Usage: "enable NUMA node stats collection",
+		},
+		cli.BoolFlag{
+			Name:  "l,load",
+			Usage: "enable load average stats collection",
+		},
+		cli.BoolFlag{
+			Name:  "thermal",
+			Usage: "enable thermal zone and hardware sensor stats collection",
+		},
+		cli.BoolFlag{
+			Name:  "psi",
+			Usage: "enable pressure stall information collection",
+		},
+		cli.BoolFlag{
+			Name:  "snmp",
+			Usage: "enable network protocol stats collection",
+		},
+		cli.StringFlag{
+			Name:  "snmp-include",
+			Usage: "collect only network protocol stats matching one of the comma-separated `GLOBS` such as 'tcp.*,tcpext.Listen*'",
+		},
+		cli.StringFlag{
+			Name:  "snmp-exclude",
+			Usage: "skip network protocol stats matching one of the comma-separated `GLOBS` such as 'icmpmsg.*'",
+		},
+		cli.BoolFlag{
+			Name:  "sockstat",
+			Usage: "enable socket stats collection",
+		},
+		cli.BoolFlag{
+			Name:  "p,proc",
+			Usage: "enable per-process stats collection for the processes selected with --pid, --pidfile or --proc-name",
+		},
+		cli.BoolFlag{
+			Name:  "schedstat",
+			Usage: "enable scheduler stats collection per CPU and for the processes selected with --pid, --pidfile or --proc-name",
+		},
+		cli.StringFlag{
+			Name:  "pid",
+			Usage: "collect per-process stats of the comma-separated `PIDS`",
+		},
+		cli.StringFlag{
+			Name:  "pidfile",
+			Usage: "collect per-process stats of the process whose PID is in `FILE`",
+		},
+		cli.StringFlag{
+			Name:  "proc-name",
+			Usage: "collect per-process stats of the processes whose names match the regular expression `PATTERN`",
+		},
+		cli.BoolFlag{
+			Name:  "threads",
+			Usage: "also collect per-thread stats of the selected processes",
+		},
+		cli.BoolFlag{
+			Name:  "cgroup",
+			Usage: "enable cgroup stats collection for the cgroups selected with --cgroup-path",
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Usage: "stop recording after `N` samples",
+		},
+		cli.DurationFlag{
+			Name:  "duration",
+			Usage: "stop recording after `DURATION` such as '5m'",
+		},
 		cli.StringFlag{
 			Name:  "delimiter",
 			Usage: "delimiter used in the output file",
@@ -52,121 +196,322 @@ var recordCommand = cli.Command{
 			Name:  "grep",
 			Usage: "filter stats using an regular expression `PATTERN`",
 		},
+		cli.BoolFlag{
+			Name:  "rate",
+			Usage: "report counters per second instead of per sampling interval",
+		},
+		cli.UintFlag{
+			Name:  "counter-width",
+			Usage: "treat counters as `BITS` wide when detecting wraparound",
+			Value: 64,
+		},
+		cli.StringFlag{
+			Name:  "time-format",
+			Usage: "format of the time column: `FORMAT` is 'epoch' (nanoseconds), 'rfc3339' or 'elapsed' (seconds since start)",
+			Value: timeFormatEpoch,
+		},
+		cli.StringFlag{
+			Name:  "proc-root",
+			Usage: "read procfs files from `DIR` instead of /proc",
+		},
+		cli.StringFlag{
+			Name:  "sys-root",
+			Usage: "read sysfs files from `DIR` instead of /sys",
+		},
+		cli.StringFlag{
+			Name:  "cgroup-path",
+			Usage: "collect cgroup and cgroup pressure stats of the cgroups at the comma-separated `PATHS` relative to /sys/fs/cgroup, such as '/system.slice/*.service'",
+		},
+		cli.StringFlag{
+			Name:  "on-error",
+			Usage: "skip a sample, disable the collector or abort when collecting fails, per collector with `POLICY` such as 'skip,net=disable,cpu=abort'",
+			Value: errorPolicySkip,
+		},
 	},
 	Action: recordAction,
 }
 
 func recordAction(ctx *cli.Context) error {
-	var stats []*ustat.Stat
-	if ctx.Bool("cpu") {
-		stats = append(stats, ustat.NewCPUsStat())
+	policies, err := parseErrorPolicies(ctx.String("on-error"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to parse error policy: %v", err), 3)
 	}
-	if ctx.Bool("int") {
-		stats = append(stats, ustat.NewInterruptsStat())
+	timeFormat := ctx.String("time-format")
+	if _, ok := timeDescriptions[timeFormat]; !ok {
+		return cli.NewExitError(fmt.Sprintf("Unknown time format: '%s'", timeFormat), 3)
 	}
-	if ctx.Bool("softirq") {
-		stats = append(stats, ustat.NewSoftIRQsStat())
+	var opts []ustat.Option
+	procRoot := ctx.String("proc-root")
+	if procRoot != "" {
+		opts = append(opts, ustat.WithProcRoot(procRoot))
+	} else {
+		procRoot = "/proc"
 	}
-	if ctx.Bool("net") {
-		stats = append(stats, ustat.NewNetStat())
+	if sysRoot := ctx.String("sys-root"); sysRoot != "" {
+		opts = append(opts, ustat.WithSysRoot(sysRoot))
 	}
-	if ctx.Bool("disk") {
-		stats = append(stats, ustat.NewDiskStat())
+	cgroups := splitList(ctx.String("cgroup-path"))
+	if ctx.Bool("cgroup") && len(cgroups) == 0 {
+		return cli.NewExitError("No cgroups selected: use --cgroup-path", 3)
 	}
-	output := os.Stdout
-	outputPath := ctx.String("output")
-	if outputPath != "" {
-		file, err := os.Create(outputPath)
+	for _, cgroup := range cgroups {
+		opts = append(opts, ustat.WithCgroup(cgroup))
+	}
+	if ctx.String("net-include") != "" || ctx.String("net-exclude") != "" {
+		opts = append(opts, ustat.WithInterfaces(splitList(ctx.String("net-include")), splitList(ctx.String("net-exclude"))))
+	}
+	if ctx.String("snmp-include") != "" || ctx.String("snmp-exclude") != "" {
+		opts = append(opts, ustat.WithSNMP(splitList(ctx.String("snmp-include")), splitList(ctx.String("snmp-exclude"))))
+	}
+	processOpts, err := parseProcessOptions(ctx)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, processOpts...)
+	if ctx.String("disk-include") != "" || ctx.String("disk-exclude") != "" {
+		opts = append(opts, ustat.WithDisks(splitList(ctx.String("disk-include")), splitList(ctx.String("disk-exclude"))))
+	}
+	if ctx.Bool("whole-disks") {
+		opts = append(opts, ustat.WithWholeDisks())
+	}
+	if ctx.Bool("dm-names") {
+		opts = append(opts, ustat.WithDeviceMapperNames())
+	}
+	if ctx.Bool("rate") {
+		opts = append(opts, ustat.WithRate())
+	}
+	counterWidth := ctx.Uint("counter-width")
+	if counterWidth == 0 || counterWidth > 64 {
+		return cli.NewExitError(fmt.Sprintf("Invalid counter width: %d", counterWidth), 3)
+	}
+	opts = append(opts, ustat.WithCounterWidth(counterWidth))
+	enableDefaults := true
+	for _, collector := range collectors {
+		if ctx.Bool(collector.name) {
+			enableDefaults = false
+		}
+	}
+	var stats []*recordedStat
+	for _, collector := range collectors {
+		if !ctx.Bool(collector.name) && !(enableDefaults && collector.byDefault) {
+			continue
+		}
+		policy := errorPolicy(policies, collector.name)
+		stat, err := collector.newStat(opts...)
 		if err != nil {
-			return cli.NewExitError(fmt.Sprintf("Unable to open file: %v", err), 2)
+			if policy == errorPolicyAbort {
+				return cli.NewExitError(fmt.Sprintf("Unable to collect %s stats: %v", collector.name, err), 2)
+			}
+			fmt.Fprintf(os.Stderr, "warning: disabling %s stats: %v\n", collector.name, err)
+			continue
 		}
-		defer file.Close()
-		output = file
+		stats = append(stats, &recordedStat{Stat: stat, name: collector.name, policy: policy})
 	}
-	pattern := ctx.String("grep")
-	if len(stats) == 0 {
-		stats = []*ustat.Stat{
-			ustat.NewCPUsStat(),
-			ustat.NewInterruptsStat(),
-			ustat.NewSoftIRQsStat(),
-			ustat.NewNetStat(),
-			ustat.NewDiskStat(),
+	var filter *regexp.Regexp
+	if pattern := ctx.String("grep"); pattern != "" {
+		filter, err = regexp.Compile(pattern)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Failed to parse grep pattern: %v", err), 3)
 		}
 	}
 	delimiter := ctx.String("delimiter")
 	delay := defaultDelay
-	args := ctx.Args()
+	args, commandArgs := splitCommand(ctx.Args())
 	if len(args) > 0 {
 		rawDelay := args[0]
 		var err error
-		delay, err = strconv.Atoi(rawDelay)
+		delay, err = parseDelay(rawDelay)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Failed to parse delay argument: '%s': %v", rawDelay, err), 3)
+		}
+	}
+	count := ctx.Int("count")
+	if count < 0 {
+		return cli.NewExitError(fmt.Sprintf("Invalid sample count: %d", count), 3)
+	}
+	duration := ctx.Duration("duration")
+	if duration < 0 {
+		return cli.NewExitError(fmt.Sprintf("Invalid duration: %v", duration), 3)
+	}
+	var command *exec.Cmd
+	if len(commandArgs) > 0 {
+		command = exec.Command(commandArgs[0], commandArgs[1:]...)
+		command.Stdin = os.Stdin
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+	}
+	file := os.Stdout
+	outputPath := ctx.String("output")
+	if command != nil && outputPath == "" {
+		// Keep the output of the command out of the recorded stats.
+		command.Stdout = os.Stderr
+	}
+	if outputPath != "" {
+		file, err = os.Create(outputPath)
 		if err != nil {
-			return cli.NewExitError(fmt.Sprintf("Failed to parse delay argument: '%s'", rawDelay), 3)
+			return cli.NewExitError(fmt.Sprintf("Unable to open file: %v", err), 2)
+		}
+	}
+	recorder := &recorder{
+		output:     bufio.NewWriter(file),
+		stats:      stats,
+		filter:     filter,
+		delimiter:  delimiter,
+		timeFormat: timeFormat,
+		start:      time.Now(),
+	}
+	err = recorder.writeHeader(hostInfo(procRoot))
+	if err == nil {
+		err = recorder.record(delay, count, duration, command)
+	}
+	if outputPath != "" {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = cli.NewExitError(fmt.Sprintf("Unable to close file: %v", closeErr), 2)
 		}
+	}
+	return err
+}
 
+// splitCommand splits the arguments of the record command into the delay
+// argument and a command to run, which follows a "--" separator. Flag parsing
+// consumes the separator if there is no delay argument before it, so the
+// separators are counted against the raw command line to detect that.
+func splitCommand(args cli.Args) (cli.Args, []string) {
+	if countSeparators(os.Args) > countSeparators(args) {
+		return nil, args
 	}
-	fmt.Fprintf(output, "# This file has been generated by ustat.\n")
-	fmt.Fprintf(output, "#\n")
-	fmt.Fprintf(output, "# Column descriptions:\n")
-	for _, stat := range stats {
-		for _, description := range stat.Descriptions {
-			if pattern != "" {
-				matched, err := regexp.MatchString(pattern, description)
-				if err != nil || !matched {
-					continue
-				}
-			}
-			fmt.Fprintf(output, "# %s\n", description)
+	for idx, arg := range args {
+		if arg == "--" {
+			return args[:idx], args[idx+1:]
 		}
 	}
-	for statIdx, stat := range stats {
-		for nameIdx, name := range stat.Names {
-			if pattern != "" {
-				matched, err := regexp.MatchString(pattern, name)
-				if err != nil || !matched {
-					continue
-				}
-			}
-			if statIdx+nameIdx == 0 {
-				fmt.Fprintf(output, "%s", name)
-			} else {
-				fmt.Fprintf(output, "%s%s", delimiter, name)
-			}
+	return args, nil
+}
+
+func countSeparators(args []string) int {
+	count := 0
+	for _, arg := range args {
+		if arg == "--" {
+			count++
 		}
 	}
-	fmt.Fprintln(output, "")
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		fmt.Println()
-		fmt.Println(sig)
-		done <- true
-	}()
-	ticker := time.NewTicker(time.Duration(delay) * time.Second)
-	go func() {
-		for _ = range ticker.C {
-			for statIdx, stat := range stats {
-				values := stat.Collector.Collect()
-				for valueIdx, value := range values {
-					if pattern != "" {
-						matched, err := regexp.MatchString(pattern, stat.Names[valueIdx])
-						if err != nil || !matched {
-							continue
-						}
-					}
-					if statIdx+valueIdx == 0 {
-						fmt.Fprintf(output, "%d", value)
-					} else {
-						fmt.Fprintf(output, "%s%d", delimiter, value)
-					}
-				}
+	return count
+}
+
+// parseProcessOptions parses the options that select the processes whose
+// stats are collected.
+func parseProcessOptions(ctx *cli.Context) ([]ustat.Option, error) {
+	var opts []ustat.Option
+	for _, rawPID := range splitList(ctx.String("pid")) {
+		pid, err := strconv.Atoi(rawPID)
+		if err != nil || pid <= 0 {
+			return nil, cli.NewExitError(fmt.Sprintf("Invalid PID: '%s'", rawPID), 3)
+		}
+		opts = append(opts, ustat.WithPIDs(pid))
+	}
+	if pidFile := ctx.String("pidfile"); pidFile != "" {
+		opts = append(opts, ustat.WithPIDFile(pidFile))
+	}
+	if pattern := ctx.String("proc-name"); pattern != "" {
+		processName, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, cli.NewExitError(fmt.Sprintf("Failed to parse process name pattern: %v", err), 3)
+		}
+		opts = append(opts, ustat.WithProcessName(processName))
+	}
+	if ctx.Bool("proc") && len(opts) == 0 {
+		return nil, cli.NewExitError("No processes selected: use --pid, --pidfile or --proc-name", 3)
+	}
+	if ctx.Bool("threads") {
+		opts = append(opts, ustat.WithThreads())
+	}
+	return opts, nil
+}
+
+// splitList splits a comma-separated list, such as a list of glob patterns.
+func splitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseDelay parses a sampling interval, which is either a duration such as
+// "100ms" or "2.5s", or a number of seconds such as "0.1".
+func parseDelay(rawDelay string) (time.Duration, error) {
+	delay, err := time.ParseDuration(rawDelay)
+	if err != nil {
+		seconds, err := strconv.ParseFloat(rawDelay, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a duration or a number of seconds")
+		}
+		delay = time.Duration(seconds * float64(time.Second))
+	}
+	if delay <= 0 {
+		return 0, fmt.Errorf("delay must be positive")
+	}
+	return delay, nil
+}
+
+// parseErrorPolicies parses a comma-separated list of error policies. An
+// entry is either a policy, which applies to all collectors, or a
+// "collector=policy" pair, which overrides the policy of one collector.
+func parseErrorPolicies(spec string) (map[string]string, error) {
+	policies := map[string]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		name, policy := "", strings.TrimSpace(entry)
+		if idx := strings.Index(policy, "="); idx >= 0 {
+			name, policy = policy[:idx], policy[idx+1:]
+			if !isCollector(name) {
+				return nil, fmt.Errorf("unknown collector '%s'", name)
 			}
-			fmt.Fprintln(output, "")
 		}
-	}()
-	<-done
+		switch policy {
+		case errorPolicySkip, errorPolicyDisable, errorPolicyAbort:
+		default:
+			return nil, fmt.Errorf("unknown policy '%s'", policy)
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+func errorPolicy(policies map[string]string, name string) string {
+	if policy, ok := policies[name]; ok {
+		return policy
+	}
+	if policy, ok := policies[""]; ok {
+		return policy
+	}
+	return errorPolicySkip
+}
+
+func isCollector(name string) bool {
+	for _, collector := range collectors {
+		if collector.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hostInfo returns the hostname and kernel version of the host whose procfs
+// is mounted at procRoot.
+func hostInfo(procRoot string) (string, string) {
+	hostname := readKernelParam(procRoot, "hostname")
+	release := readKernelParam(procRoot, "osrelease")
+	version := readKernelParam(procRoot, "version")
+	return hostname, strings.TrimSpace(release + " " + version)
+}
 
-	return nil
+func readKernelParam(procRoot string, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, "sys/kernel", name))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
 }
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/penberg/ustat"
+	"gopkg.in/urfave/cli.v1"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// missingValue is written in place of values that could not be collected.
+const missingValue = "NaN"
+
+// Time column formats.
+const (
+	timeFormatEpoch   = "epoch"
+	timeFormatRFC3339 = "rfc3339"
+	timeFormatElapsed = "elapsed"
+)
+
+var timeDescriptions = map[string]string{
+	timeFormatEpoch:   "Sample time in nanoseconds since the Unix epoch",
+	timeFormatRFC3339: "Sample time in RFC 3339 format",
+	timeFormatElapsed: "Sample time in seconds since the start of recording",
+}
+
+// A recordedStat is a Stat that is being recorded.
+type recordedStat struct {
+	*ustat.Stat
+	name     string
+	policy   string
+	disabled bool
+}
+
+// A recorder writes samples of stats into a self-describing DSV file.
+type recorder struct {
+	output     *bufio.Writer
+	stats      []*recordedStat
+	filter     *regexp.Regexp
+	delimiter  string
+	timeFormat string
+	start      time.Time
+}
+
+// record samples stats every delay until it is interrupted by a signal, count
+// samples have been written or the samples that fit in duration have been
+// written. A zero count or duration means that there is no such limit. The
+// duration limit is a number of samples rather than a deadline, because ticks
+// arrive slightly after each multiple of delay, so a recording of 1s at 100ms
+// has exactly ten rows.
+//
+// If command is not nil, record starts it and samples stats until it exits,
+// writing a final sample for the partial interval before the exit. The exit
+// status of the command is returned as an exit error.
+func (recorder *recorder) record(delay time.Duration, count int, duration time.Duration, command *exec.Cmd) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	var exited chan error
+	if command != nil {
+		if err := command.Start(); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to run command: %v", err), 2)
+		}
+		exited = make(chan error, 1)
+		go func() {
+			exited <- command.Wait()
+		}()
+		event := fmt.Sprintf("command started: pid %d: %s", command.Process.Pid, strings.Join(command.Args, " "))
+		if err := recorder.writeMarker(time.Now(), event); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Failed to record stats: %v", err), 2)
+		}
+	}
+	if duration > 0 {
+		limit := int(duration / delay)
+		if limit == 0 {
+			limit = 1
+		}
+		if count == 0 || limit < count {
+			count = limit
+		}
+	}
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+	samples := 0
+	sampling := true
+	for {
+		select {
+		case sig := <-sigs:
+			if command == nil {
+				fmt.Fprintln(os.Stderr, sig)
+				return nil
+			}
+			// Forward SIGINT even though a terminal delivers it to the
+			// command's process group already, because it may have been
+			// sent to us only, for example with kill from a script.
+			command.Process.Signal(sig)
+		case waitErr := <-exited:
+			now := time.Now()
+			if sampling {
+				if err := recorder.writeRow(now); err != nil {
+					return cli.NewExitError(fmt.Sprintf("Failed to record stats: %v", err), 2)
+				}
+			}
+			if err := recorder.writeMarker(now, "command exited: "+commandStatus(waitErr)); err != nil {
+				return cli.NewExitError(fmt.Sprintf("Failed to record stats: %v", err), 2)
+			}
+			return commandExitError(waitErr)
+		case now := <-ticker.C:
+			if err := recorder.writeRow(now); err != nil {
+				return cli.NewExitError(fmt.Sprintf("Failed to record stats: %v", err), 2)
+			}
+			samples++
+			if count > 0 && samples >= count {
+				if command == nil {
+					return nil
+				}
+				// Stop sampling, but keep waiting for the command to exit.
+				ticker.Stop()
+				sampling = false
+			}
+		}
+	}
+}
+
+func (recorder *recorder) writeHeader(hostname string, kernel string) error {
+	output := recorder.output
+	fmt.Fprintf(output, "# This file has been generated by ustat.\n")
+	fmt.Fprintf(output, "#\n")
+	fmt.Fprintf(output, "# Start time: %s\n", recorder.start.Format(time.RFC3339Nano))
+	fmt.Fprintf(output, "# Hostname: %s\n", hostname)
+	fmt.Fprintf(output, "# Kernel: %s\n", kernel)
+	fmt.Fprintf(output, "#\n")
+	recorder.writeColumns()
+	return output.Flush()
+}
+
+// writeSegment starts a new header segment after the columns of stats have
+// changed. The segment describes all columns again, so that each segment of
+// the file can be interpreted on its own.
+func (recorder *recorder) writeSegment(now time.Time) error {
+	output := recorder.output
+	fmt.Fprintf(output, "#\n")
+	fmt.Fprintf(output, "# %s columns changed\n", recorder.formatTime(now))
+	fmt.Fprintf(output, "#\n")
+	recorder.writeColumns()
+	return output.Flush()
+}
+
+func (recorder *recorder) writeColumns() {
+	output := recorder.output
+	fmt.Fprintf(output, "# Column descriptions:\n")
+	fmt.Fprintf(output, "# time = %s\n", timeDescriptions[recorder.timeFormat])
+	for _, stat := range recorder.stats {
+		for _, description := range stat.Descriptions {
+			if recorder.matches(description) {
+				fmt.Fprintf(output, "# %s\n", description)
+			}
+		}
+	}
+	fmt.Fprintf(output, "#\n")
+	fmt.Fprintf(output, "# Column metadata:\n")
+	for _, stat := range recorder.stats {
+		for nameIdx, name := range stat.Names {
+			if recorder.matches(name) {
+				fmt.Fprintf(output, "# %s%s\n", name, formatMetadata(stat.Stat, nameIdx))
+			}
+		}
+	}
+	fmt.Fprintf(output, "time")
+	for _, stat := range recorder.stats {
+		for _, name := range stat.Names {
+			if recorder.matches(name) {
+				fmt.Fprintf(output, "%s%s", recorder.delimiter, name)
+			}
+		}
+	}
+	fmt.Fprintln(output, "")
+}
+
+// formatMetadata formats the kind, unit and source of a column as key=value
+// pairs. Stats that do not describe their columns have no metadata.
+func formatMetadata(stat *ustat.Stat, idx int) string {
+	metadata := ""
+	if idx < len(stat.Kinds) {
+		metadata += fmt.Sprintf(" kind=%s", stat.Kinds[idx])
+	}
+	if idx < len(stat.Units) {
+		metadata += fmt.Sprintf(" unit=%s", stat.Units[idx])
+	}
+	if idx < len(stat.Sources) {
+		metadata += fmt.Sprintf(" source=%s", stat.Sources[idx])
+	}
+	return metadata
+}
+
+// writeRow collects and writes a sample of stats. Entities that disappeared
+// since the previous sample are written as missing values, after which a new
+// header segment is started if the columns of any stat changed.
+func (recorder *recorder) writeRow(now time.Time) error {
+	rows := make([][]float64, len(recorder.stats))
+	for statIdx, stat := range recorder.stats {
+		values, err := stat.collect()
+		if err != nil {
+			return err
+		}
+		rows[statIdx] = values
+	}
+	output := recorder.output
+	fmt.Fprintf(output, "%s", recorder.formatTime(now))
+	for statIdx, stat := range recorder.stats {
+		values := rows[statIdx]
+		for nameIdx, name := range stat.Names {
+			if !recorder.matches(name) {
+				continue
+			}
+			value := missingValue
+			if nameIdx < len(values) {
+				value = formatValue(values[nameIdx])
+			}
+			fmt.Fprintf(output, "%s%s", recorder.delimiter, value)
+		}
+	}
+	fmt.Fprintln(output, "")
+	if err := output.Flush(); err != nil {
+		return err
+	}
+	changed := false
+	for _, stat := range recorder.stats {
+		updater, ok := stat.Collector.(ustat.ColumnUpdater)
+		if ok && !stat.disabled && updater.UpdateColumns(stat.Stat) {
+			changed = true
+		}
+	}
+	if changed {
+		return recorder.writeSegment(now)
+	}
+	return nil
+}
+
+func (recorder *recorder) matches(name string) bool {
+	return recorder.filter == nil || recorder.filter.MatchString(name)
+}
+
+// formatValue formats a value with at most three decimals.
+func formatValue(value float64) string {
+	if math.IsNaN(value) {
+		return missingValue
+	}
+	formatted := strconv.FormatFloat(value, 'f', 3, 64)
+	formatted = strings.TrimRight(formatted, "0")
+	return strings.TrimSuffix(formatted, ".")
+}
+
+func (recorder *recorder) formatTime(now time.Time) string {
+	switch recorder.timeFormat {
+	case timeFormatRFC3339:
+		return now.Format(time.RFC3339Nano)
+	case timeFormatElapsed:
+		return strconv.FormatFloat(now.Sub(recorder.start).Seconds(), 'f', 3, 64)
+	default:
+		return strconv.FormatInt(now.UnixNano(), 10)
+	}
+}
+
+// collect collects values from a stat and applies the stat's error policy
+// on failure. A nil slice without an error means that the values are missing.
+func (stat *recordedStat) collect() ([]float64, error) {
+	if stat.disabled {
+		return nil, nil
+	}
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		switch stat.policy {
+		case errorPolicyAbort:
+			return nil, fmt.Errorf("%s: %v", stat.name, err)
+		case errorPolicyDisable:
+			fmt.Fprintf(os.Stderr, "warning: disabling %s stats: %v\n", stat.name, err)
+			stat.disabled = true
+		default:
+			fmt.Fprintf(os.Stderr, "warning: skipping %s stats sample: %v\n", stat.name, err)
+		}
+		return nil, nil
+	}
+	return values, nil
+}
+
+// writeMarker writes a comment row, which marks an event during recording.
+func (recorder *recorder) writeMarker(now time.Time, event string) error {
+	fmt.Fprintf(recorder.output, "# %s %s\n", recorder.formatTime(now), event)
+	return recorder.output.Flush()
+}
+
+func commandStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// commandExitError returns an error, which makes ustat exit with the same
+// status as a command that has exited with err.
+func commandExitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return cli.NewExitError("", 128+int(status.Signal()))
+			}
+			return cli.NewExitError("", status.ExitStatus())
+		}
+	}
+	return cli.NewExitError(fmt.Sprintf("Failed to wait for command: %v", err), 2)
+}
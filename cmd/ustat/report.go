@@ -4,9 +4,12 @@ import (
 	"encoding/csv"
 	"fmt"
 	"github.com/montanaflynn/stats"
+	"github.com/penberg/ustat"
 	"gopkg.in/urfave/cli.v1"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,6 +24,17 @@ type interruptStat struct {
 	values map[string][]float64
 }
 
+type numaStat struct {
+	values map[string][]float64
+}
+
+// columnMetadata describes a recorded column.
+type columnMetadata struct {
+	kind   string
+	unit   string
+	source string
+}
+
 var reportCommand = cli.Command{
 	Name:      "report",
 	Usage:     "summarise stats that are recored to a file",
@@ -50,10 +64,13 @@ func reportAction(ctx *cli.Context) error {
 	reader := csv.NewReader(file)
 	comma, _ := utf8.DecodeRuneInString(delimiter)
 	reader.Comma = comma
+	reader.FieldsPerRecord = -1
 	header := map[string]int{}
+	metadata := map[string]columnMetadata{}
 	cpuStats := map[string]cpuStat{}
 	interruptStats := map[string]interruptStat{}
 	softIrqStats := map[string]interruptStat{}
+	numaStats := map[string]numaStat{}
 	sampleCount := 0
 	for {
 		record, err := reader.Read()
@@ -64,22 +81,31 @@ func reportAction(ctx *cli.Context) error {
 			continue
 		}
 		if strings.HasPrefix(record[0], "#") {
+			parseMetadata(strings.Join(record, delimiter), metadata)
 			continue
 		}
-		if len(header) == 0 {
+		if len(header) == 0 || record[0] == "time" {
+			header = map[string]int{}
 			for idx, column := range record {
 				header[column] = idx
 			}
 			continue
 		}
+		numaSample := map[string]map[string]float64{}
 		for column, idx := range header {
+			if idx >= len(record) {
+				continue
+			}
 			result := strings.Split(column, ".")
 			resource := result[0]
-			if strings.HasPrefix(resource, "cpu") {
-				rawValue := record[idx]
-				value, err := strconv.Atoi(rawValue)
+			switch columnResource(column, metadata) {
+			case "cpu":
+				value, ok, err := parseValue(record[idx])
 				if err != nil {
-					return cli.NewExitError(fmt.Sprintf("Unable to parse value '%s': %v", rawValue, err), 2)
+					return err
+				}
+				if !ok {
+					continue
 				}
 				class := result[1]
 				stat, ok := cpuStats[resource]
@@ -87,32 +113,38 @@ func reportAction(ctx *cli.Context) error {
 					stat = cpuStat{values: map[string][]float64{}}
 				}
 				values := stat.values[class]
-				values = append(values, float64(value))
+				values = append(values, value)
 				stat.values[class] = values
 				cpuStats[resource] = stat
-			}
-			if strings.HasPrefix(resource, "int") {
-				rawValue := record[idx]
-				value, err := strconv.Atoi(rawValue)
+			case "int":
+				value, ok, err := parseValue(record[idx])
 				if err != nil {
-					return cli.NewExitError(fmt.Sprintf("Unable to parse value '%s': %v", rawValue, err), 2)
+					return err
+				}
+				if !ok {
+					continue
 				}
 				class := result[1]
+				// System-wide interrupts, such as ERR, are not counted per CPU.
+				if class == "total" {
+					continue
+				}
 				stat, ok := interruptStats[resource]
 				if !ok {
 					stat = interruptStat{values: map[string][]float64{}}
 				}
 				values := stat.values[class]
-				values = append(values, float64(value))
+				values = append(values, value)
 				stat.values[class] = values
 				interruptStats[resource] = stat
-			}
-			if strings.HasPrefix(resource, "softirq") {
+			case "softirq":
 				resource := result[1]
-				rawValue := record[idx]
-				value, err := strconv.Atoi(rawValue)
+				value, ok, err := parseValue(record[idx])
 				if err != nil {
-					return cli.NewExitError(fmt.Sprintf("Unable to parse value '%s': %v", rawValue, err), 2)
+					return err
+				}
+				if !ok {
+					continue
 				}
 				class := result[2]
 				stat, ok := softIrqStats[resource]
@@ -120,10 +152,34 @@ func reportAction(ctx *cli.Context) error {
 					stat = interruptStat{values: map[string][]float64{}}
 				}
 				values := stat.values[class]
-				values = append(values, float64(value))
+				values = append(values, value)
 				stat.values[class] = values
 				softIrqStats[resource] = stat
+			case "numa":
+				if len(result) != 3 {
+					continue
+				}
+				value, ok, err := parseValue(record[idx])
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				node := result[1]
+				if _, ok := numaSample[node]; !ok {
+					numaSample[node] = map[string]float64{}
+				}
+				numaSample[node][result[2]] = value
+			}
+		}
+		for node, sample := range numaSample {
+			stat, ok := numaStats[node]
+			if !ok {
+				stat = numaStat{values: map[string][]float64{}}
 			}
+			addNUMARatios(stat, sample)
+			numaStats[node] = stat
 		}
 		sampleCount++
 	}
@@ -148,16 +204,10 @@ func reportAction(ctx *cli.Context) error {
 		fmt.Printf("  %-4s", cpu)
 		cpuStats := cpuStats[cpu]
 		for _, class := range classes {
-			values := cpuStats.values[class]
-			mean, err := stats.Mean(values)
+			format, err := summarize(cpuStats.values[class])
 			if err != nil {
-				return cli.NewExitError(fmt.Sprintf("%v", err), 2)
+				return err
 			}
-			stddev, err := stats.StandardDeviation(values)
-			if err != nil {
-				return cli.NewExitError(fmt.Sprintf("%v", err), 2)
-			}
-			format := fmt.Sprintf("%.2f (%.2f)", mean, stddev)
 			fmt.Printf(" %-12s", format)
 		}
 		fmt.Printf("\n")
@@ -171,6 +221,57 @@ func reportAction(ctx *cli.Context) error {
 		return err
 	}
 	fmt.Printf("\n")
+	if len(numaStats) > 0 {
+		if err := printNUMA(numaStats); err != nil {
+			return err
+		}
+		fmt.Printf("\n")
+	}
+	return nil
+}
+
+// addNUMARatios adds the share of local and remote allocations and of
+// allocations that missed the intended node in a sample of a NUMA node.
+// Samples without allocations have no ratios.
+func addNUMARatios(stat numaStat, sample map[string]float64) {
+	local, localOk := sample["local_node"]
+	remote, remoteOk := sample["other_node"]
+	if localOk && remoteOk && local+remote > 0 {
+		stat.values["local"] = append(stat.values["local"], local/(local+remote)*100)
+		stat.values["remote"] = append(stat.values["remote"], remote/(local+remote)*100)
+	}
+	hit, hitOk := sample["numa_hit"]
+	miss, missOk := sample["numa_miss"]
+	if hitOk && missOk && hit+miss > 0 {
+		stat.values["miss"] = append(stat.values["miss"], miss/(hit+miss)*100)
+	}
+}
+
+func printNUMA(numaStats map[string]numaStat) error {
+	nodes := []string{}
+	for node := range numaStats {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	classes := []string{"local", "remote", "miss"}
+	fmt.Printf("NUMA allocations (%%), mean (SD):\n")
+	fmt.Printf("\n")
+	fmt.Printf("  %-8s", "node")
+	for _, class := range classes {
+		fmt.Printf(" %-14s", class)
+	}
+	fmt.Printf("\n")
+	for _, node := range nodes {
+		fmt.Printf("  %-8s", node)
+		for _, class := range classes {
+			format, err := summarize(numaStats[node].values[class])
+			if err != nil {
+				return err
+			}
+			fmt.Printf(" %-14s", format)
+		}
+		fmt.Printf("\n")
+	}
 	return nil
 }
 
@@ -202,18 +303,114 @@ func printInterrupts(title string, interruptStats map[string]interruptStat, cpus
 				fmt.Printf("%20s", "")
 				continue
 			}
-			mean, err := stats.Mean(values)
-			if err != nil {
-				return cli.NewExitError(fmt.Sprintf("%v", err), 2)
-			}
-			stddev, err := stats.StandardDeviation(values)
+			format, err := summarize(values)
 			if err != nil {
-				return cli.NewExitError(fmt.Sprintf("%v", err), 2)
+				return err
 			}
-			format := fmt.Sprintf("%.2f (%.2f)", mean, stddev)
 			fmt.Printf("%20s", format)
 		}
 		fmt.Printf("\n")
 	}
 	return nil
 }
+
+// parseValue parses a recorded value. Missing values are reported as not ok.
+func parseValue(rawValue string) (float64, bool, error) {
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return 0, false, cli.NewExitError(fmt.Sprintf("Unable to parse value '%s': %v", rawValue, err), 2)
+	}
+	if math.IsNaN(value) {
+		return 0, false, nil
+	}
+	return value, true, nil
+}
+
+// summarize formats the mean and standard deviation of values.
+func summarize(values []float64) (string, error) {
+	if len(values) == 0 {
+		return "-", nil
+	}
+	mean, err := stats.Mean(values)
+	if err != nil {
+		return "", cli.NewExitError(fmt.Sprintf("%v", err), 2)
+	}
+	stddev, err := stats.StandardDeviation(values)
+	if err != nil {
+		return "", cli.NewExitError(fmt.Sprintf("%v", err), 2)
+	}
+	return fmt.Sprintf("%.2f (%.2f)", mean, stddev), nil
+}
+
+// parseMetadata parses a column metadata comment such as
+// "# net.lo.rx.bytes kind=counter unit=bytes source=/proc/net/dev".
+func parseMetadata(comment string, metadata map[string]columnMetadata) {
+	fields := strings.Fields(strings.TrimPrefix(comment, "#"))
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "kind=") {
+		return
+	}
+	var column columnMetadata
+	for _, field := range fields[1:] {
+		idx := strings.Index(field, "=")
+		if idx < 0 {
+			continue
+		}
+		switch field[:idx] {
+		case "kind":
+			column.kind = field[idx+1:]
+		case "unit":
+			column.unit = field[idx+1:]
+		case "source":
+			column.source = field[idx+1:]
+		}
+	}
+	metadata[fields[0]] = column
+}
+
+// columnResource returns the resource that a column describes. The resource
+// is derived from column metadata if the file has it, and from the column
+// name otherwise.
+func columnResource(column string, metadata map[string]columnMetadata) string {
+	if meta, ok := metadata[column]; ok {
+		switch filepath.Base(meta.source) {
+		case "stat":
+			// Per-process CPU time, such as proc.<pid>.utime, is also a
+			// percentage from a stat file.
+			if !isCPUName(strings.Split(column, ".")[0]) {
+				return ""
+			}
+			if meta.kind == ustat.Ratio.String() && meta.unit == string(ustat.Percent) {
+				return "cpu"
+			}
+		case "interrupts":
+			return "int"
+		case "softirqs":
+			return "softirq"
+		case "numastat":
+			return "numa"
+		}
+		return ""
+	}
+	prefix := strings.Split(column, ".")[0]
+	switch {
+	case strings.HasPrefix(prefix, "cpu"):
+		return "cpu"
+	case strings.HasPrefix(prefix, "int"):
+		return "int"
+	case strings.HasPrefix(prefix, "softirq"):
+		return "softirq"
+	case prefix == "numa":
+		return "numa"
+	}
+	return ""
+}
+
+// isCPUName reports whether name is "cpu" or the name of a CPU such as
+// "cpu0".
+func isCPUName(name string) bool {
+	if name == "cpu" {
+		return true
+	}
+	_, err := strconv.Atoi(strings.TrimPrefix(name, "cpu"))
+	return strings.HasPrefix(name, "cpu") && err == nil
+}
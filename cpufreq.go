@@ -0,0 +1,270 @@
+package ustat
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+)
+
+type cpuFreqCollector struct {
+	opts     *options
+	cpus     []cpuFreq
+	names    []string
+	columns  []cpuFreqColumn
+	counters *counterSet
+}
+
+// A cpuFreq is the frequency and the idle states of a CPU in sysfs. The
+// frequency is missing if the CPU has no cpufreq driver, and the idle states
+// are missing if it has no cpuidle driver, which is common in VMs.
+type cpuFreq struct {
+	cpu     string
+	dir     string
+	freq    uint64
+	hasFreq bool
+	states  []cpuIdleState
+}
+
+// A cpuIdleState is an idle state of a CPU in
+// /sys/devices/system/cpu/cpu<N>/cpuidle/state<M>.
+type cpuIdleState struct {
+	name  string
+	dir   string
+	usage uint64
+	time  uint64
+}
+
+// A cpuFreqColumn is a column of a CPU frequency or idle state stat. The
+// state is empty for the frequency column.
+type cpuFreqColumn struct {
+	cpu      string
+	state    string
+	statType string
+	source   string
+}
+
+const sysCPUPath = "devices/system/cpu"
+
+// NewCPUFreqStat returns a new Stat, which collects the current frequency of
+// each CPU from /sys/devices/system/cpu/cpu<N>/cpufreq and the number of
+// entries to, the time spent in and the residency of each idle state from
+// /sys/devices/system/cpu/cpu<N>/cpuidle. An error is returned if neither
+// is supported.
+func NewCPUFreqStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	reader := &cpuFreqCollector{opts: o}
+	cpus, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	names, columns := parseCPUFreqColumns(cpus)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: CPU frequency and idle states are not supported", o.sysPath(sysCPUPath))
+	}
+	counterNames, values := parseCPUIdleCounters(cpus)
+	reader.cpus = cpus
+	reader.names = names
+	reader.columns = columns
+	reader.counters = newCounterSet(counterNames, values, o)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+// Collect returns the current CPU frequencies, the change in the idle state
+// counters and the residency of each idle state, which is the change in its
+// idle time relative to the elapsed time.
+func (reader *cpuFreqCollector) Collect() ([]float64, error) {
+	cpus, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	reader.cpus = cpus
+	names, values := parseCPUIdleCounters(cpus)
+	deltas, elapsed := reader.counters.deltas(names, values)
+	freqs := map[string]uint64{}
+	for _, cpu := range cpus {
+		if cpu.hasFreq {
+			freqs[cpu.cpu] = cpu.freq
+		}
+	}
+	var result []float64
+	for idx, column := range reader.columns {
+		switch column.statType {
+		case "freq":
+			freq, ok := freqs[column.cpu]
+			if !ok {
+				result = append(result, math.NaN())
+				continue
+			}
+			result = append(result, float64(freq))
+		case "residency":
+			if elapsed <= 0 {
+				result = append(result, math.NaN())
+				continue
+			}
+			delta := deltas.value(cpuIdleStatName(column.cpu, column.state, "time"))
+			result = append(result, delta/(elapsed.Seconds()*1000000)*100)
+		default:
+			result = append(result, reader.counters.normalize(deltas.value(reader.names[idx]), elapsed))
+		}
+	}
+	return result, nil
+}
+
+// UpdateColumns updates stat to match the CPUs and idle states in the last
+// sample.
+func (reader *cpuFreqCollector) UpdateColumns(stat *Stat) bool {
+	names, columns := parseCPUFreqColumns(reader.cpus)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.columns = columns
+	reader.describe(stat)
+	return true
+}
+
+func (reader *cpuFreqCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	stat.Sources = nil
+	for idx, column := range reader.columns {
+		name := reader.names[idx]
+		switch column.statType {
+		case "freq":
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s current frequency (kHz)", name, column.cpu))
+			stat.Kinds = append(stat.Kinds, Gauge)
+			stat.Units = append(stat.Units, Kilohertz)
+		case "usage":
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s number of entries to idle state %s (%s)", name, column.cpu, column.state, reader.opts.unit("entries")))
+			stat.Kinds = append(stat.Kinds, Counter)
+			stat.Units = append(stat.Units, reader.opts.counterUnit(Count))
+		case "time":
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s time spent in idle state %s (%s)", name, column.cpu, column.state, reader.opts.unit("us")))
+			stat.Kinds = append(stat.Kinds, Counter)
+			stat.Units = append(stat.Units, reader.opts.counterUnit(Microseconds))
+		case "residency":
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s time spent in idle state %s as percentage of elapsed time", name, column.cpu, column.state))
+			stat.Kinds = append(stat.Kinds, Ratio)
+			stat.Units = append(stat.Units, Percent)
+		}
+		stat.Sources = append(stat.Sources, column.source)
+	}
+}
+
+// read reads the frequency and the idle states of the CPUs in ascending order
+// of CPU number. Offline CPUs have neither, so they have no columns.
+func (reader *cpuFreqCollector) read() ([]cpuFreq, error) {
+	dir := reader.opts.sysPath(sysCPUPath)
+	ids, err := readNumberedEntries(dir, "cpu")
+	if err != nil {
+		return nil, err
+	}
+	var cpus []cpuFreq
+	for _, id := range ids {
+		cpu := cpuFreq{
+			cpu: fmt.Sprintf("cpu%d", id),
+			dir: filepath.Join(dir, fmt.Sprintf("cpu%d", id)),
+		}
+		if freq, err := readUint(filepath.Join(cpu.dir, "cpufreq", "scaling_cur_freq")); err == nil {
+			cpu.freq = freq
+			cpu.hasFreq = true
+		}
+		states, err := readCPUIdleStates(filepath.Join(cpu.dir, "cpuidle"))
+		if err != nil {
+			return nil, err
+		}
+		cpu.states = states
+		cpus = append(cpus, cpu)
+	}
+	return cpus, nil
+}
+
+// readCPUIdleStates reads the idle states of a CPU in ascending order of
+// state number. The states are named after the name file of the state, such
+// as "C1E", or after the state directory if the name is missing or is not
+// unique. It returns no states if the CPU has no cpuidle directory.
+func readCPUIdleStates(dir string) ([]cpuIdleState, error) {
+	ids, err := readNumberedEntries(dir, "state")
+	if err != nil {
+		return nil, err
+	}
+	var states []cpuIdleState
+	seen := map[string]bool{}
+	for _, id := range ids {
+		state := cpuIdleState{dir: filepath.Join(dir, fmt.Sprintf("state%d", id))}
+		usage, err := readUint(filepath.Join(state.dir, "usage"))
+		if err != nil {
+			continue
+		}
+		time, err := readUint(filepath.Join(state.dir, "time"))
+		if err != nil {
+			continue
+		}
+		state.usage = usage
+		state.time = time
+		state.name = fmt.Sprintf("state%d", id)
+		if name := readSysfsName(filepath.Join(state.dir, "name")); name != "" && !seen[name] {
+			state.name = name
+		}
+		seen[state.name] = true
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func cpuFreqStatName(cpu string) string {
+	return fmt.Sprintf("cpufreq.%s.freq", cpu)
+}
+
+func cpuIdleStatName(cpu string, state string, statType string) string {
+	return fmt.Sprintf("cpuidle.%s.%s.%s", cpu, state, statType)
+}
+
+func parseCPUFreqColumns(cpus []cpuFreq) ([]string, []cpuFreqColumn) {
+	var names []string
+	var columns []cpuFreqColumn
+	for _, cpu := range cpus {
+		if cpu.hasFreq {
+			names = append(names, cpuFreqStatName(cpu.cpu))
+			columns = append(columns, cpuFreqColumn{
+				cpu:      cpu.cpu,
+				statType: "freq",
+				source:   filepath.Join(cpu.dir, "cpufreq", "scaling_cur_freq"),
+			})
+		}
+		for _, state := range cpu.states {
+			for _, statType := range []string{"usage", "time", "residency"} {
+				source := filepath.Join(state.dir, statType)
+				if statType == "residency" {
+					source = filepath.Join(state.dir, "time")
+				}
+				names = append(names, cpuIdleStatName(cpu.cpu, state.name, statType))
+				columns = append(columns, cpuFreqColumn{
+					cpu:      cpu.cpu,
+					state:    state.name,
+					statType: statType,
+					source:   source,
+				})
+			}
+		}
+	}
+	return names, columns
+}
+
+func parseCPUIdleCounters(cpus []cpuFreq) ([]string, []uint64) {
+	var names []string
+	var values []uint64
+	for _, cpu := range cpus {
+		for _, state := range cpu.states {
+			names = append(names, cpuIdleStatName(cpu.cpu, state.name, "usage"))
+			values = append(values, state.usage)
+			names = append(names, cpuIdleStatName(cpu.cpu, state.name, "time"))
+			values = append(values, state.time)
+		}
+	}
+	return names, values
+}
@@ -0,0 +1,65 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCPUFreq(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpus := filepath.Join(dir, "devices", "system", "cpu")
+	writeFile(t, filepath.Join(cpus, "cpu0", "cpufreq", "scaling_cur_freq"), "2400000\n")
+	writeIdleState := func(cpu string, state string, name string, usage string, time string) {
+		stateDir := filepath.Join(cpus, cpu, "cpuidle", state)
+		writeFile(t, filepath.Join(stateDir, "name"), name+"\n")
+		writeFile(t, filepath.Join(stateDir, "usage"), usage+"\n")
+		writeFile(t, filepath.Join(stateDir, "time"), time+"\n")
+	}
+	writeIdleState("cpu0", "state0", "POLL", "10", "100")
+	writeIdleState("cpu0", "state1", "C1", "5", "1000")
+	writeIdleState("cpu0", "state2", "C1", "1", "0")
+	// CPUs without a cpufreq driver have no frequency column.
+	writeIdleState("cpu1", "state0", "POLL", "20", "200")
+	stat, err := NewCPUFreqStat(WithSysRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cpufreq.cpu0.freq"}
+	wantKinds := []Kind{Gauge}
+	wantUnits := []Unit{Kilohertz}
+	for _, state := range []string{"cpu0.POLL", "cpu0.C1", "cpu0.state2", "cpu1.POLL"} {
+		for _, statType := range []string{"usage", "time", "residency"} {
+			want = append(want, "cpuidle."+state+"."+statType)
+		}
+		wantKinds = append(wantKinds, Counter, Counter, Ratio)
+		wantUnits = append(wantUnits, Count, Microseconds, Percent)
+	}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	if len(stat.Descriptions) != len(want) || len(stat.Sources) != len(want) {
+		t.Errorf("len(Descriptions), len(Sources) = %d, %d, want %d", len(stat.Descriptions), len(stat.Sources), len(want))
+	}
+	writeFile(t, filepath.Join(cpus, "cpu0", "cpufreq", "scaling_cur_freq"), "1200000\n")
+	writeIdleState("cpu0", "state1", "C1", "8", "1600")
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 1200000 || values[4] != 3 || values[5] != 600 || values[6] <= 0 || values[3] != 0 {
+		t.Errorf("Collect() = %v, want freq 1200000, C1 usage 3, C1 time 600, C1 residency > 0 and POLL residency 0", values)
+	}
+}
@@ -3,30 +3,37 @@ package ustat
 import (
 	"fmt"
 	procfs "github.com/c9s/goprocinfo/linux"
+	"math"
 )
 
 type procStatCollector struct {
-	prev *procfs.Stat
+	path     string
+	opts     *options
+	cpus     []string
+	prev     *procfs.Stat
+	counters *counterSet
 }
 
-const procStatPath = "/proc/stat"
+const procStatPath = "stat"
 
 // NewCPUsStat returns a new Stat, which collects CPU stats from /proc/stat.
-func NewCPUsStat() *Stat {
-	stat, err := procfs.ReadStat(procStatPath)
+func NewCPUsStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	path := o.procPath(procStatPath)
+	stat, err := procfs.ReadStat(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	names := parseCPUStatNames(stat)
-	descriptions := parseCPUStatDescriptions(stat)
-	return &Stat{
-		Names:        names,
-		Descriptions: descriptions,
-		Collector: &procStatCollector{
-			prev: stat,
-		},
+	reader := &procStatCollector{
+		path:     path,
+		opts:     o,
+		cpus:     parseCPUIds(stat),
+		prev:     stat,
+		counters: newCounterSet(cpuCounterNames, parseCPUCounters(stat), o),
 	}
-	return nil
+	result := &Stat{Collector: reader}
+	reader.describe(result)
+	return result, nil
 }
 
 func interval(before []uint64, after []uint64) uint64 {
@@ -41,14 +48,35 @@ func interval(before []uint64, after []uint64) uint64 {
 	return curr - prev
 }
 
-func (reader *procStatCollector) Collect() []uint64 {
-	stat, err := procfs.ReadStat(procStatPath)
+func (reader *procStatCollector) Collect() ([]float64, error) {
+	stat, err := procfs.ReadStat(reader.path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	values := parseCPUStats(stat, reader.prev)
+	values := parseCPUStats(stat, reader.prev, reader.cpus)
+	values = append(values, reader.counters.update(cpuCounterNames, cpuCounterNames, parseCPUCounters(stat))...)
+	values = append(values, parseCPUGauges(stat)...)
 	reader.prev = stat
-	return values
+	return values, nil
+}
+
+// UpdateColumns updates stat to match the CPUs in the last sample.
+func (reader *procStatCollector) UpdateColumns(stat *Stat) bool {
+	cpus := parseCPUIds(reader.prev)
+	if equalStrings(cpus, reader.cpus) {
+		return false
+	}
+	reader.cpus = cpus
+	reader.describe(stat)
+	return true
+}
+
+func (reader *procStatCollector) describe(stat *Stat) {
+	stat.Names = parseCPUStatNames(reader.prev)
+	stat.Descriptions = parseCPUStatDescriptions(reader.prev, reader.opts)
+	stat.Kinds = parseCPUStatKinds(reader.prev)
+	stat.Units = parseCPUStatUnits(reader.prev, reader.opts)
+	stat.Sources = sources(reader.path, len(stat.Names))
 }
 
 var cpuStatTypes = []string{
@@ -89,12 +117,31 @@ func parseCPUStatNames(stat *procfs.Stat) []string {
 			names = append(names, name)
 		}
 	}
-	names = append(names, "ctxt.switch")
+	names = append(names, cpuCounterNames...)
+	names = append(names, cpuGaugeNames...)
 	return names
 }
 
-func parseCPUStatDescriptions(stat *procfs.Stat) []string {
+// parseCPUStatKinds returns the kinds of CPU stats. CPU utilization is the
+// change in CPU time relative to the elapsed CPU time.
+func parseCPUStatKinds(stat *procfs.Stat) []Kind {
+	count := (len(stat.CPUStats) + 1) * len(cpuStatTypes)
+	result := append(kinds(Ratio, count), kinds(Counter, len(cpuCounterNames))...)
+	return append(result, kinds(Gauge, len(cpuGaugeNames))...)
+}
+
+func parseCPUStatUnits(stat *procfs.Stat, o *options) []Unit {
+	count := (len(stat.CPUStats) + 1) * len(cpuStatTypes)
+	result := append(units(Percent, count), units(o.counterUnit(Count), len(cpuCounterNames))...)
+	return append(result, units(Count, len(cpuGaugeNames))...)
+}
+
+func parseCPUStatDescriptions(stat *procfs.Stat, o *options) []string {
 	var descriptions []string
+	for _, cpuStatType := range cpuStatTypes {
+		description := fmt.Sprintf("cpu.%s = All CPUs %s", cpuStatType, cpuStatDescriptions[cpuStatType])
+		descriptions = append(descriptions, description)
+	}
 	for _, cpuStat := range stat.CPUStats {
 		for _, cpuStatType := range cpuStatTypes {
 			cpuStatDescription := cpuStatDescriptions[cpuStatType]
@@ -102,45 +149,125 @@ func parseCPUStatDescriptions(stat *procfs.Stat) []string {
 			descriptions = append(descriptions, description)
 		}
 	}
-	descriptions = append(descriptions, "ctx.switch = Number of context switches")
+	for _, name := range cpuCounterNames {
+		description := fmt.Sprintf("%s = %s (%s)", name, cpuCounterDescriptions[name], o.unit(cpuCounterUnits[name]))
+		descriptions = append(descriptions, description)
+	}
+	for _, name := range cpuGaugeNames {
+		descriptions = append(descriptions, fmt.Sprintf("%s = %s (tasks)", name, cpuGaugeDescriptions[name]))
+	}
 	return descriptions
 }
 
-func parseCPUStats(curr *procfs.Stat, prev *procfs.Stat) []uint64 {
-	var values []uint64
-	interval := runtime(curr.CPUStatAll) - runtime(prev.CPUStatAll)
-	values = append(values, difference(curr.CPUStatAll.User, prev.CPUStatAll.User, interval))
-	values = append(values, difference(curr.CPUStatAll.Nice, prev.CPUStatAll.Nice, interval))
-	values = append(values, difference(curr.CPUStatAll.System, prev.CPUStatAll.System, interval))
-	values = append(values, difference(curr.CPUStatAll.Idle, prev.CPUStatAll.Idle, interval))
-	values = append(values, difference(curr.CPUStatAll.IOWait, prev.CPUStatAll.IOWait, interval))
-	values = append(values, difference(curr.CPUStatAll.IRQ, prev.CPUStatAll.IRQ, interval))
-	values = append(values, difference(curr.CPUStatAll.SoftIRQ, prev.CPUStatAll.SoftIRQ, interval))
-	values = append(values, difference(curr.CPUStatAll.Steal, prev.CPUStatAll.Steal, interval))
-	values = append(values, difference(curr.CPUStatAll.Guest, prev.CPUStatAll.Guest, interval))
-	values = append(values, difference(curr.CPUStatAll.GuestNice, prev.CPUStatAll.GuestNice, interval))
-	for idx, currCpuStat := range curr.CPUStats {
-		prevCpuStat := prev.CPUStats[idx]
-		interval := runtime(currCpuStat) - runtime(prevCpuStat)
-		values = append(values, difference(currCpuStat.User, prevCpuStat.User, interval))
-		values = append(values, difference(currCpuStat.Nice, prevCpuStat.Nice, interval))
-		values = append(values, difference(currCpuStat.System, prevCpuStat.System, interval))
-		values = append(values, difference(currCpuStat.Idle, prevCpuStat.Idle, interval))
-		values = append(values, difference(currCpuStat.IOWait, prevCpuStat.IOWait, interval))
-		values = append(values, difference(currCpuStat.IRQ, prevCpuStat.IRQ, interval))
-		values = append(values, difference(currCpuStat.SoftIRQ, prevCpuStat.SoftIRQ, interval))
-		values = append(values, difference(currCpuStat.Steal, prevCpuStat.Steal, interval))
-		values = append(values, difference(currCpuStat.Guest, prevCpuStat.Guest, interval))
-		values = append(values, difference(currCpuStat.GuestNice, prevCpuStat.GuestNice, interval))
-	}
-	values = append(values, curr.ContextSwitches-prev.ContextSwitches)
+func parseCPUStats(curr *procfs.Stat, prev *procfs.Stat, cpus []string) []float64 {
+	values := parseCPUPercentages(curr.CPUStatAll, prev.CPUStatAll)
+	for _, cpu := range cpus {
+		currCpuStat, currOk := findCPUStat(curr, cpu)
+		prevCpuStat, prevOk := findCPUStat(prev, cpu)
+		if !currOk || !prevOk {
+			for _ = range cpuStatTypes {
+				values = append(values, math.NaN())
+			}
+			continue
+		}
+		values = append(values, parseCPUPercentages(currCpuStat, prevCpuStat)...)
+	}
 	return values
 }
 
+func parseCPUPercentages(curr procfs.CPUStat, prev procfs.CPUStat) []float64 {
+	interval := cpuInterval(curr, prev)
+	return []float64{
+		difference(curr.User, prev.User, interval),
+		difference(curr.Nice, prev.Nice, interval),
+		difference(curr.System, prev.System, interval),
+		difference(curr.Idle, prev.Idle, interval),
+		difference(curr.IOWait, prev.IOWait, interval),
+		difference(curr.IRQ, prev.IRQ, interval),
+		difference(curr.SoftIRQ, prev.SoftIRQ, interval),
+		difference(curr.Steal, prev.Steal, interval),
+		difference(curr.Guest, prev.Guest, interval),
+		difference(curr.GuestNice, prev.GuestNice, interval),
+	}
+}
+
+// parseCPUIds returns the ids of the CPUs in /proc/stat, which identify CPUs
+// even when other CPUs are hot-added or removed.
+func parseCPUIds(stat *procfs.Stat) []string {
+	var ids []string
+	for _, cpuStat := range stat.CPUStats {
+		ids = append(ids, cpuStat.Id)
+	}
+	return ids
+}
+
+func findCPUStat(stat *procfs.Stat, id string) (procfs.CPUStat, bool) {
+	for _, cpuStat := range stat.CPUStats {
+		if cpuStat.Id == id {
+			return cpuStat, true
+		}
+	}
+	return procfs.CPUStat{}, false
+}
+
+var cpuCounterNames = []string{"ctxt.switch", "intr.count", "processes.forked"}
+
+var cpuCounterDescriptions = map[string]string{
+	"ctxt.switch":      "Number of context switches",
+	"intr.count":       "Number of interrupts serviced",
+	"processes.forked": "Number of processes and threads created",
+}
+
+// cpuCounterUnits are the units of the counters in column descriptions.
+var cpuCounterUnits = map[string]string{
+	"ctxt.switch":      "switches",
+	"intr.count":       "interrupts",
+	"processes.forked": "forks",
+}
+
+// parseCPUCounters returns the cumulative counters from /proc/stat, which are
+// reported as changes rather than as percentages of CPU time.
+func parseCPUCounters(stat *procfs.Stat) []uint64 {
+	return []uint64{stat.ContextSwitches, stat.Interrupts, stat.Processes}
+}
+
+var cpuGaugeNames = []string{"procs.running", "procs.blocked"}
+
+var cpuGaugeDescriptions = map[string]string{
+	"procs.running": "Number of runnable tasks",
+	"procs.blocked": "Number of tasks blocked waiting for I/O",
+}
+
+// parseCPUGauges returns the run queue stats from /proc/stat, which are
+// reported as is.
+func parseCPUGauges(stat *procfs.Stat) []float64 {
+	return []float64{float64(stat.ProcsRunning), float64(stat.ProcsBlocked)}
+}
+
+// cpuInterval returns the number of jiffies that elapsed on a CPU between two
+// samples.
+func cpuInterval(curr procfs.CPUStat, prev procfs.CPUStat) uint64 {
+	currRuntime, prevRuntime := runtime(curr), runtime(prev)
+	if currRuntime < prevRuntime {
+		return 0
+	}
+	return currRuntime - prevRuntime
+}
+
 func runtime(cpuStat procfs.CPUStat) uint64 {
-	return cpuStat.User + cpuStat.Nice + cpuStat.System + cpuStat.Idle + cpuStat.IOWait + cpuStat.IRQ + cpuStat.SoftIRQ
+	return cpuStat.User + cpuStat.Nice + cpuStat.System + cpuStat.Idle + cpuStat.IOWait + cpuStat.IRQ + cpuStat.SoftIRQ + cpuStat.Steal
 }
 
-func difference(curr uint64, prev uint64, interval uint64) uint64 {
-	return uint64(float64(curr-prev) / float64(interval) * 100)
+// difference returns the change of a CPU time counter as a percentage of
+// interval. With short sampling intervals only a handful of jiffies elapse
+// between samples, so the interval may be zero and counters such as iowait
+// may even go backwards. The percentage is NaN if no jiffies elapsed.
+func difference(curr uint64, prev uint64, interval uint64) float64 {
+	if interval == 0 {
+		return math.NaN()
+	}
+	if curr < prev {
+		return 0
+	}
+	return float64(curr-prev) / float64(interval) * 100
 }
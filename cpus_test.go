@@ -0,0 +1,42 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCPUsDescriptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "stat"), `cpu  200 0 100 1000 0 0 0 0 0 0
+cpu0 100 0 50 500 0 0 0 0 0 0
+cpu1 100 0 50 500 0 0 0 0 0 0
+intr 1000
+ctxt 5000
+btime 1500000000
+processes 300
+procs_running 2
+procs_blocked 1
+`)
+	stat, err := NewCPUsStat(WithProcRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat.Names) != 3*len(cpuStatTypes)+len(cpuCounterNames)+len(cpuGaugeNames) {
+		t.Fatalf("len(Names) = %d, want %d", len(stat.Names), 3*len(cpuStatTypes)+len(cpuCounterNames)+len(cpuGaugeNames))
+	}
+	if len(stat.Descriptions) != len(stat.Names) || len(stat.Kinds) != len(stat.Names) || len(stat.Units) != len(stat.Names) || len(stat.Sources) != len(stat.Names) {
+		t.Fatalf("len(Descriptions), len(Kinds), len(Units), len(Sources) = %d, %d, %d, %d, want %d", len(stat.Descriptions), len(stat.Kinds), len(stat.Units), len(stat.Sources), len(stat.Names))
+	}
+	for idx, name := range stat.Names {
+		if !strings.HasPrefix(stat.Descriptions[idx], name+" = ") {
+			t.Errorf("Descriptions[%d] = %q, want description of %s", idx, stat.Descriptions[idx], name)
+		}
+	}
+}
@@ -2,80 +2,392 @@ package ustat
 
 import (
 	"fmt"
-	procfs "github.com/c9s/goprocinfo/linux"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type diskStatCollector struct {
-	values []uint64
+	path     string
+	opts     *options
+	stats    []diskStat
+	names    []string
+	columns  []diskColumn
+	counters *counterSet
 }
 
-const procDiskStatPath = "/proc/diskstats"
+// A diskStat is a line of /proc/diskstats. The number of fields depends on
+// the kernel version: discard fields were added in Linux 4.18 and flush
+// fields in Linux 5.5.
+type diskStat struct {
+	name   string
+	fields []uint64
+}
+
+// A diskColumn is a column of a disk stat.
+type diskColumn struct {
+	device   string
+	statType string
+}
+
+const procDiskStatPath = "diskstats"
 
 // NewDiskStat returns a new Stat, which collects disk stats from /proc/diskstats.
-func NewDiskStat() *Stat {
-	stats, err := procfs.ReadDiskStats(procDiskStatPath)
+func NewDiskStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	path := o.procPath(procDiskStatPath)
+	reader := &diskStatCollector{
+		path: path,
+		opts: o,
+	}
+	stats, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	names, values := parseDiskStats(stats)
+	reader.stats = stats
+	reader.counters = newCounterSet(names, values, o)
+	reader.names, reader.columns = parseDiskStatColumns(stats)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+// Collect returns the change in the disk counters and the iostat-style
+// derived stats, which are calculated from the changes in the counters
+// before rate normalization.
+func (reader *diskStatCollector) Collect() ([]float64, error) {
+	stats, err := reader.read()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	names := parseDiskStatNames(stats)
-	descriptions := parseDiskStatDescriptions(stats)
-	values := parseDiskStats(stats)
-	return &Stat{
-		Names:        names,
-		Descriptions: descriptions,
-		Collector:    &diskStatCollector{values: values},
+	reader.stats = stats
+	names, values := parseDiskStats(stats)
+	deltas, elapsed := reader.counters.deltas(names, values)
+	current := counterMap(names, values)
+	var result []float64
+	for idx, name := range reader.names {
+		column := reader.columns[idx]
+		switch column.statType {
+		case "inflight":
+			value, ok := current[name]
+			if !ok {
+				result = append(result, math.NaN())
+				continue
+			}
+			result = append(result, float64(value))
+		case "read.iops", "write.iops", "read.kbps", "write.kbps", "read.await", "write.await", "await", "queue.size", "util":
+			result = append(result, diskDerivedStat(column.device, column.statType, deltas, elapsed))
+		default:
+			result = append(result, reader.counters.normalize(deltas.value(name), elapsed))
+		}
 	}
+	return result, nil
 }
 
-func (reader *diskStatCollector) Collect() []uint64 {
-	stats, err := procfs.ReadDiskStats(procDiskStatPath)
+// read reads the disk stats, renames device-mapper devices and filters out
+// disks that are not selected by the options.
+func (reader *diskStatCollector) read() ([]diskStat, error) {
+	stats, err := readDiskStats(reader.path)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	var result []diskStat
+	for _, stat := range stats {
+		name := stat.name
+		if reader.opts.dmNames && strings.HasPrefix(name, "dm-") {
+			name = readDeviceMapperName(reader.opts, name)
+		}
+		if !reader.opts.disks.matches(stat.name, name) {
+			continue
+		}
+		if reader.opts.wholeDisks && !isWholeDisk(reader.opts, stat.name) {
+			continue
+		}
+		stat.name = name
+		result = append(result, stat)
 	}
-	values := parseDiskStats(stats)
-	diff := Difference(reader.values, values)
-	reader.values = values
-	return diff
+	return result, nil
 }
 
+// UpdateColumns updates stat to match the disks in the last sample.
+func (reader *diskStatCollector) UpdateColumns(stat *Stat) bool {
+	names, columns := parseDiskStatColumns(reader.stats)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.columns = columns
+	reader.describe(stat)
+	return true
+}
+
+func (reader *diskStatCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	for _, column := range reader.columns {
+		name := diskStatName(column.device, column.statType)
+		description := diskStatDescriptions[column.statType]
+		unit := diskStatUnits[column.statType]
+		kind := diskStatKind(column.statType)
+		if kind == Counter {
+			stat.Units = append(stat.Units, reader.opts.counterUnit(diskStatUnit(unit)))
+			unit = reader.opts.unit(unit)
+		} else {
+			stat.Units = append(stat.Units, Unit(unit))
+		}
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s %s (%s)", name, column.device, description, unit))
+		stat.Kinds = append(stat.Kinds, kind)
+	}
+	stat.Sources = sources(reader.path, len(reader.names))
+}
+
+// diskStatTypes are the fields of /proc/diskstats after the device name.
 var diskStatTypes = []string{
+	"read.ios",
+	"read.merges",
 	"read.sectors",
+	"read.ticks",
+	"write.ios",
+	"write.merges",
 	"write.sectors",
+	"write.ticks",
+	"inflight",
+	"io.ticks",
+	"queue.ticks",
+	"discard.ios",
+	"discard.merges",
+	"discard.sectors",
+	"discard.ticks",
+	"flush.ios",
+	"flush.ticks",
+}
+
+// diskDerivedStatTypes are stats that are derived from the fields of
+// /proc/diskstats like iostat does.
+var diskDerivedStatTypes = []string{
+	"read.iops",
+	"write.iops",
+	"read.kbps",
+	"write.kbps",
+	"read.await",
+	"write.await",
+	"await",
+	"queue.size",
+	"util",
 }
 
 var diskStatDescriptions = map[string]string{
-	"read.sectors":  "Number of 512 byte sectors read",
-	"write.sectors": "Number of 512 byte sectors written",
+	"read.ios":        "Number of reads completed",
+	"read.merges":     "Number of reads merged",
+	"read.sectors":    "Number of 512 byte sectors read",
+	"read.ticks":      "Time spent reading",
+	"write.ios":       "Number of writes completed",
+	"write.merges":    "Number of writes merged",
+	"write.sectors":   "Number of 512 byte sectors written",
+	"write.ticks":     "Time spent writing",
+	"inflight":        "Number of I/Os in flight",
+	"io.ticks":        "Time spent doing I/Os",
+	"queue.ticks":     "Weighted time spent doing I/Os",
+	"discard.ios":     "Number of discards completed",
+	"discard.merges":  "Number of discards merged",
+	"discard.sectors": "Number of 512 byte sectors discarded",
+	"discard.ticks":   "Time spent discarding",
+	"flush.ios":       "Number of flushes completed",
+	"flush.ticks":     "Time spent flushing",
+	"read.iops":       "Reads completed per second",
+	"write.iops":      "Writes completed per second",
+	"read.kbps":       "Kilobytes read per second",
+	"write.kbps":      "Kilobytes written per second",
+	"read.await":      "Average time to complete a read",
+	"write.await":     "Average time to complete a write",
+	"await":           "Average time to complete an I/O",
+	"queue.size":      "Average number of I/Os in the queue",
+	"util":            "Time spent doing I/Os as percentage of elapsed time",
 }
 
-func parseDiskStatNames(stats []procfs.DiskStat) []string {
-	var names []string
-	for _, stat := range stats {
-		for _, diskStatType := range diskStatTypes {
-			name := fmt.Sprintf("disk.%s.%s", stat.Name, diskStatType)
-			names = append(names, name)
+var diskStatUnits = map[string]string{
+	"read.ios":        "ios",
+	"read.merges":     "merges",
+	"read.sectors":    "sectors",
+	"read.ticks":      "ms",
+	"write.ios":       "ios",
+	"write.merges":    "merges",
+	"write.sectors":   "sectors",
+	"write.ticks":     "ms",
+	"inflight":        "count",
+	"io.ticks":        "ms",
+	"queue.ticks":     "ms",
+	"discard.ios":     "ios",
+	"discard.merges":  "merges",
+	"discard.sectors": "sectors",
+	"discard.ticks":   "ms",
+	"flush.ios":       "ios",
+	"flush.ticks":     "ms",
+	"read.iops":       "count/s",
+	"write.iops":      "count/s",
+	"read.kbps":       "kB/s",
+	"write.kbps":      "kB/s",
+	"read.await":      "ms",
+	"write.await":     "ms",
+	"await":           "ms",
+	"queue.size":      "count",
+	"util":            "percent",
+}
+
+func diskStatUnit(unit string) Unit {
+	switch unit {
+	case "sectors":
+		return Sectors
+	case "ms":
+		return Milliseconds
+	}
+	return Count
+}
+
+func diskStatKind(statType string) Kind {
+	if statType == "inflight" {
+		return Gauge
+	}
+	for _, derivedType := range diskDerivedStatTypes {
+		if statType == derivedType {
+			return Ratio
 		}
 	}
-	return names
+	return Counter
+}
+
+// diskDerivedStat calculates a derived stat of a device from the change in
+// disk counters during elapsed time.
+func diskDerivedStat(device string, statType string, deltas counterDeltas, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return math.NaN()
+	}
+	delta := func(statType string) float64 {
+		return deltas.value(diskStatName(device, statType))
+	}
+	seconds := elapsed.Seconds()
+	millis := seconds * 1000
+	switch statType {
+	case "read.iops":
+		return delta("read.ios") / seconds
+	case "write.iops":
+		return delta("write.ios") / seconds
+	case "read.kbps":
+		return delta("read.sectors") / 2 / seconds
+	case "write.kbps":
+		return delta("write.sectors") / 2 / seconds
+	case "read.await":
+		return await(delta("read.ticks"), delta("read.ios"))
+	case "write.await":
+		return await(delta("write.ticks"), delta("write.ios"))
+	case "await":
+		return await(delta("read.ticks")+delta("write.ticks"), delta("read.ios")+delta("write.ios"))
+	case "queue.size":
+		return delta("queue.ticks") / millis
+	case "util":
+		return delta("io.ticks") / millis * 100
+	}
+	return math.NaN()
+}
+
+// await returns the average time to complete an I/O, which is zero if no
+// I/Os were completed.
+func await(ticks float64, ios float64) float64 {
+	if ios == 0 {
+		return 0
+	}
+	return ticks / ios
+}
+
+// sysBlockName returns the name of a device in /sys/block, where slashes in
+// device names such as "cciss/c0d0" are replaced with exclamation marks.
+func sysBlockName(name string) string {
+	return strings.Replace(name, "/", "!", -1)
+}
+
+func isWholeDisk(o *options, name string) bool {
+	_, err := os.Stat(o.sysPath(filepath.Join("block", sysBlockName(name))))
+	return err == nil
 }
 
-func parseDiskStatDescriptions(stats []procfs.DiskStat) []string {
-	var descriptions []string
+// readDeviceMapperName returns the name of a device-mapper device, or the
+// kernel name of the device if it has no name.
+func readDeviceMapperName(o *options, name string) string {
+	data, err := ioutil.ReadFile(o.sysPath(filepath.Join("block", sysBlockName(name), "dm", "name")))
+	if err != nil {
+		return name
+	}
+	dmName := strings.TrimSpace(string(data))
+	if dmName == "" {
+		return name
+	}
+	return dmName
+}
+
+func diskStatName(device string, statType string) string {
+	return fmt.Sprintf("disk.%s.%s", device, statType)
+}
+
+// readDiskStats reads /proc/diskstats, which has the major and minor device
+// numbers, the device name and the stat fields on each line.
+func readDiskStats(path string) ([]diskStat, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stats []diskStat
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 14 {
+			return nil, fmt.Errorf("%s: invalid line '%s'", path, line)
+		}
+		stat := diskStat{name: fields[2]}
+		for idx, field := range fields[3:] {
+			if idx >= len(diskStatTypes) {
+				break
+			}
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %v", path, stat.name, err)
+			}
+			stat.fields = append(stat.fields, value)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+func parseDiskStatColumns(stats []diskStat) ([]string, []diskColumn) {
+	var names []string
+	var columns []diskColumn
 	for _, stat := range stats {
-		for _, diskStatType := range diskStatTypes {
-			diskStatDescription := diskStatDescriptions[diskStatType]
-			description := fmt.Sprintf("disk.%s.%s = %s %s", stat.Name, diskStatType, stat.Name, diskStatDescription)
-			descriptions = append(descriptions, description)
+		statTypes := append(diskStatTypes[:len(stat.fields):len(stat.fields)], diskDerivedStatTypes...)
+		for _, statType := range statTypes {
+			names = append(names, diskStatName(stat.name, statType))
+			columns = append(columns, diskColumn{device: stat.name, statType: statType})
 		}
 	}
-	return descriptions
+	return names, columns
 }
 
-func parseDiskStats(stats []procfs.DiskStat) []uint64 {
+func parseDiskStats(stats []diskStat) ([]string, []uint64) {
+	var names []string
 	var values []uint64
 	for _, stat := range stats {
-		values = append(values, stat.ReadSectors)
-		values = append(values, stat.WriteSectors)
+		for idx, value := range stat.fields {
+			names = append(names, diskStatName(stat.name, diskStatTypes[idx]))
+			values = append(values, value)
+		}
 	}
-	return values
+	return names, values
 }
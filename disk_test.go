@@ -0,0 +1,98 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "diskstats")
+	// Kernels before Linux 4.18 have no discard and flush fields.
+	writeFile(t, path, "   8       0 sda 100 10 2000 50 40 4 800 30 2 70 90\n")
+	stat, err := NewDiskStat(WithProcRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat.Names) != 11+len(diskDerivedStatTypes) {
+		t.Fatalf("len(Names) = %d, want %d", len(stat.Names), 11+len(diskDerivedStatTypes))
+	}
+	if len(stat.Descriptions) != len(stat.Names) || len(stat.Kinds) != len(stat.Names) || len(stat.Units) != len(stat.Names) || len(stat.Sources) != len(stat.Names) {
+		t.Fatalf("len(Descriptions), len(Kinds), len(Units), len(Sources) = %d, %d, %d, %d, want %d", len(stat.Descriptions), len(stat.Kinds), len(stat.Units), len(stat.Sources), len(stat.Names))
+	}
+	columns := map[string]int{}
+	for idx, name := range stat.Names {
+		columns[name] = idx
+	}
+	for _, test := range []struct {
+		name string
+		kind Kind
+		unit Unit
+	}{
+		{"disk.sda.read.ios", Counter, Count},
+		{"disk.sda.read.sectors", Counter, Sectors},
+		{"disk.sda.write.ticks", Counter, Milliseconds},
+		{"disk.sda.inflight", Gauge, Count},
+		{"disk.sda.read.kbps", Ratio, "kB/s"},
+		{"disk.sda.util", Ratio, Percent},
+	} {
+		idx, ok := columns[test.name]
+		if !ok {
+			t.Errorf("missing column %s", test.name)
+			continue
+		}
+		if stat.Kinds[idx] != test.kind || stat.Units[idx] != test.unit {
+			t.Errorf("%s: kind, unit = %v, %v, want %v, %v", test.name, stat.Kinds[idx], stat.Units[idx], test.kind, test.unit)
+		}
+	}
+	writeFile(t, path, "   8       0 sda 110 10 2400 60 40 4 800 30 1 80 95\n")
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{
+		"disk.sda.read.ios":     10,
+		"disk.sda.read.sectors": 400,
+		"disk.sda.write.ios":    0,
+		"disk.sda.inflight":     1,
+	} {
+		if got := values[columns[name]]; got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestDiskDerivedStat(t *testing.T) {
+	deltas := counterDeltas{
+		"disk.sda.read.ios":     10,
+		"disk.sda.read.sectors": 400,
+		"disk.sda.read.ticks":   30,
+		"disk.sda.write.ios":    0,
+		"disk.sda.write.ticks":  0,
+		"disk.sda.io.ticks":     500,
+		"disk.sda.queue.ticks":  1500,
+	}
+	for _, test := range []struct {
+		statType string
+		want     float64
+	}{
+		{"read.iops", 5},
+		{"read.kbps", 100},
+		{"read.await", 3},
+		{"write.await", 0},
+		{"await", 3},
+		{"queue.size", 0.75},
+		{"util", 25},
+	} {
+		if got := diskDerivedStat("sda", test.statType, deltas, 2*time.Second); got != test.want {
+			t.Errorf("diskDerivedStat(%s) = %v, want %v", test.statType, got, test.want)
+		}
+	}
+}
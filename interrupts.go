@@ -2,68 +2,163 @@ package ustat
 
 import (
 	"fmt"
-	procfs "github.com/c9s/goprocinfo/linux"
+	"io/ioutil"
+	"strconv"
+	"strings"
 )
 
 type procInterruptsCollector struct {
-	counts []uint64
+	path       string
+	opts       *options
+	interrupts []interrupt
+	names      []string
+	counters   *counterSet
 }
 
-const procInterruptsPath = "/proc/interrupts"
+const procInterruptsPath = "interrupts"
 
 // NewInterruptsStat returns a new Stat, which collects interrupt stats from /proc/interrupts.
-func NewInterruptsStat() *Stat {
-	interrupts, err := procfs.ReadInterrupts(procInterruptsPath)
+func NewInterruptsStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	path := o.procPath(procInterruptsPath)
+	interrupts, err := readInterrupts(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	names := parseInterruptNames(interrupts)
-	descriptions := parseInterruptDescriptions(interrupts)
-	counts := parseInterruptCounts(interrupts)
-	return &Stat{
-		Names:        names,
-		Descriptions: descriptions,
-		Collector:    &procInterruptsCollector{counts: counts},
+	reader := &procInterruptsCollector{
+		path:       path,
+		opts:       o,
+		interrupts: interrupts,
+		names:      names,
+		counters:   newCounterSet(names, parseInterruptCounts(interrupts), o),
 	}
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
 }
 
-func (reader *procInterruptsCollector) Collect() []uint64 {
-	interrupts, err := procfs.ReadInterrupts(procInterruptsPath)
+func (reader *procInterruptsCollector) Collect() ([]float64, error) {
+	interrupts, err := readInterrupts(reader.path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	counts := parseInterruptCounts(interrupts)
-	diff := Difference(reader.counts, counts)
-	reader.counts = counts
-	return diff
+	reader.interrupts = interrupts
+	return reader.counters.update(reader.names, parseInterruptNames(interrupts), parseInterruptCounts(interrupts)), nil
 }
 
-func parseInterruptNames(interrupts *procfs.Interrupts) []string {
+// UpdateColumns updates stat to match the interrupts in the last sample.
+func (reader *procInterruptsCollector) UpdateColumns(stat *Stat) bool {
+	names := parseInterruptNames(reader.interrupts)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.describe(stat)
+	return true
+}
+
+func (reader *procInterruptsCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = parseInterruptDescriptions(reader.interrupts, reader.opts)
+	stat.Kinds = kinds(Counter, len(reader.names))
+	stat.Units = units(reader.opts.counterUnit(Count), len(reader.names))
+	stat.Sources = sources(reader.path, len(reader.names))
+}
+
+// An interrupt is a line of /proc/interrupts or /proc/softirqs with the
+// counts of an interrupt on each CPU.
+type interrupt struct {
+	name        string
+	description string
+	cpus        []int
+	counts      []uint64
+}
+
+// readInterrupts reads /proc/interrupts or /proc/softirqs, which have a
+// header row with the CPUs, such as:
+//
+//	         CPU0       CPU2
+//	0:         22          0   IO-APIC   2-edge      timer
+//
+// The counts are matched to the CPU ids in the header rather than to their
+// position, because /proc/interrupts only lists the online CPUs. Some rows,
+// such as "ERR", have a single count for the system; see systemWide.
+func readInterrupts(path string) ([]interrupt, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	var cpus []int
+	for _, field := range strings.Fields(lines[0]) {
+		cpu, err := strconv.Atoi(strings.TrimPrefix(field, "CPU"))
+		if err != nil || !strings.HasPrefix(field, "CPU") {
+			return nil, fmt.Errorf("%s: invalid header '%s'", path, lines[0])
+		}
+		cpus = append(cpus, cpu)
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("%s: missing header", path)
+	}
+	var interrupts []interrupt
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		intr := interrupt{name: strings.TrimSuffix(fields[0], ":")}
+		fields = fields[1:]
+		for len(fields) > 0 && len(intr.counts) < len(cpus) {
+			count, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				break
+			}
+			intr.counts = append(intr.counts, count)
+			fields = fields[1:]
+		}
+		intr.cpus = cpus[:len(intr.counts)]
+		intr.description = strings.Join(fields, " ")
+		interrupts = append(interrupts, intr)
+	}
+	return interrupts, nil
+}
+
+// systemWide reports whether the interrupt is counted for the whole system
+// rather than per CPU. In /proc/interrupts, such rows, like "ERR" and "MIS",
+// have a single count and no description.
+func (intr interrupt) systemWide() bool {
+	return len(intr.counts) == 1 && intr.description == ""
+}
+
+func parseInterruptNames(interrupts []interrupt) []string {
 	var names []string
-	for _, interrupt := range interrupts.Interrupts {
-		for cpu := range interrupt.Counts {
-			name := fmt.Sprintf("int%s.cpu%d", interrupt.Name, cpu)
+	for _, interrupt := range interrupts {
+		if interrupt.systemWide() {
+			names = append(names, fmt.Sprintf("int%s.total", interrupt.name))
+			continue
+		}
+		for _, cpu := range interrupt.cpus {
+			name := fmt.Sprintf("int%s.cpu%d", interrupt.name, cpu)
 			names = append(names, name)
 		}
 	}
 	return names
 }
 
-func parseInterruptDescriptions(interrupts *procfs.Interrupts) []string {
+func parseInterruptDescriptions(interrupts []interrupt, o *options) []string {
 	var descriptions []string
-	for _, interrupt := range interrupts.Interrupts {
-		description := fmt.Sprintf("intr.%s = %s", interrupt.Name, interrupt.Description)
+	for _, interrupt := range interrupts {
+		description := fmt.Sprintf("intr.%s = %s (%s)", interrupt.name, interrupt.description, o.unit("interrupts"))
 		descriptions = append(descriptions, description)
 	}
 	return descriptions
 }
 
-func parseInterruptCounts(interrupts *procfs.Interrupts) []uint64 {
+func parseInterruptCounts(interrupts []interrupt) []uint64 {
 	var values []uint64
-	for _, interrupt := range interrupts.Interrupts {
-		for _, count := range interrupt.Counts {
-			values = append(values, count)
-		}
+	for _, interrupt := range interrupts {
+		values = append(values, interrupt.counts...)
 	}
 	return values
 }
@@ -0,0 +1,49 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInterruptsCPUOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "interrupts")
+	writeFile(t, path, `           CPU0       CPU1       CPU2
+  0:         10         20         30   IO-APIC   2-edge      timer
+ERR:          0
+`)
+	stat, err := NewInterruptsStat(WithProcRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"int0.cpu0", "int0.cpu1", "int0.cpu2", "intERR.total"}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	writeFile(t, path, `           CPU0       CPU2
+  0:         11         35   IO-APIC   2-edge      timer
+ERR:          0
+`)
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 1 || !math.IsNaN(values[1]) || values[2] != 5 || values[3] != 0 {
+		t.Errorf("Collect() = %v, want [1 NaN 5 0]", values)
+	}
+	if !stat.Collector.(ColumnUpdater).UpdateColumns(stat) {
+		t.Fatal("UpdateColumns() = false, want true")
+	}
+	want = []string{"int0.cpu0", "int0.cpu2", "intERR.total"}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Errorf("Names = %v, want %v", stat.Names, want)
+	}
+}
@@ -0,0 +1,66 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// A keyValue is a named value in a file with one value on each line.
+type keyValue struct {
+	key   string
+	value uint64
+	unit  string
+}
+
+// readKeyValues reads a file, which has a key followed by a value and an
+// optional unit on each line, such as /proc/meminfo or /proc/vmstat.
+func readKeyValues(path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		key := strings.TrimSuffix(fields[0], ":")
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+		}
+		keyValue := keyValue{key: key, value: value}
+		if len(fields) > 2 {
+			keyValue.unit = fields[2]
+		}
+		values = append(values, keyValue)
+	}
+	return values, nil
+}
+
+func keyValueMap(values []keyValue) map[string]uint64 {
+	result := make(map[string]uint64, len(values))
+	for _, value := range values {
+		result[value.key] = value.value
+	}
+	return result
+}
+
+func keyValueKeys(values []keyValue) []string {
+	var keys []string
+	for _, value := range values {
+		keys = append(keys, value.key)
+	}
+	return keys
+}
+
+func keyValueValues(values []keyValue) []uint64 {
+	var result []uint64
+	for _, value := range values {
+		result = append(result, value.value)
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package ustat
+
+import (
+	procfs "github.com/c9s/goprocinfo/linux"
+)
+
+type procLoadAvgCollector struct {
+	path string
+}
+
+const procLoadAvgPath = "loadavg"
+
+var loadAvgNames = []string{
+	"load.1min",
+	"load.5min",
+	"load.15min",
+	"load.runnable",
+	"load.tasks",
+	"load.lastpid",
+}
+
+var loadAvgDescriptions = []string{
+	"load.1min = Load average over the last minute",
+	"load.5min = Load average over the last 5 minutes",
+	"load.15min = Load average over the last 15 minutes",
+	"load.runnable = Number of runnable tasks (tasks)",
+	"load.tasks = Number of tasks (tasks)",
+	"load.lastpid = PID of the most recently created task",
+}
+
+// NewLoadAvgStat returns a new Stat, which collects load average stats from /proc/loadavg.
+func NewLoadAvgStat(opts ...Option) (*Stat, error) {
+	path := newOptions(opts).procPath(procLoadAvgPath)
+	if _, err := procfs.ReadLoadAvg(path); err != nil {
+		return nil, err
+	}
+	return &Stat{
+		Names:        loadAvgNames,
+		Descriptions: loadAvgDescriptions,
+		Kinds:        kinds(Gauge, len(loadAvgNames)),
+		Units:        units(Count, len(loadAvgNames)),
+		Sources:      sources(path, len(loadAvgNames)),
+		Collector:    &procLoadAvgCollector{path: path},
+	}, nil
+}
+
+func (reader *procLoadAvgCollector) Collect() ([]float64, error) {
+	loadAvg, err := procfs.ReadLoadAvg(reader.path)
+	if err != nil {
+		return nil, err
+	}
+	return []float64{
+		loadAvg.Last1Min,
+		loadAvg.Last5Min,
+		loadAvg.Last15Min,
+		float64(loadAvg.ProcessRunning),
+		float64(loadAvg.ProcessTotal),
+		float64(loadAvg.LastPID),
+	}, nil
+}
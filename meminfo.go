@@ -0,0 +1,130 @@
+package ustat
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type procMemInfoCollector struct {
+	path string
+	keys []string
+}
+
+const procMemInfoPath = "meminfo"
+
+// NewMemInfoStat returns a new Stat, which collects memory stats from /proc/meminfo.
+func NewMemInfoStat(opts ...Option) (*Stat, error) {
+	path := newOptions(opts).procPath(procMemInfoPath)
+	memInfo, err := readKeyValues(path)
+	if err != nil {
+		return nil, err
+	}
+	names := parseMemInfoNames(memInfo)
+	descriptions := parseMemInfoDescriptions(memInfo)
+	var keys []string
+	for _, value := range memInfo {
+		keys = append(keys, value.key)
+	}
+	return &Stat{
+		Names:        names,
+		Descriptions: descriptions,
+		Kinds:        kinds(Gauge, len(names)),
+		Units:        parseMemInfoUnits(memInfo),
+		Sources:      sources(path, len(names)),
+		Collector:    &procMemInfoCollector{path: path, keys: keys},
+	}, nil
+}
+
+func (reader *procMemInfoCollector) Collect() ([]float64, error) {
+	memInfo, err := readKeyValues(reader.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMemInfo(memInfo, reader.keys), nil
+}
+
+var memInfoDescriptions = map[string]string{
+	"MemTotal":        "Total usable memory",
+	"MemFree":         "Free memory",
+	"MemAvailable":    "Memory available for starting new applications without swapping",
+	"Buffers":         "Memory in buffer cache",
+	"Cached":          "Memory in page cache",
+	"SwapCached":      "Memory that was swapped out and is still in swap cache",
+	"Active":          "Memory used recently",
+	"Inactive":        "Memory not used recently",
+	"Dirty":           "Memory waiting to be written back to disk",
+	"Writeback":       "Memory being written back to disk",
+	"AnonPages":       "Anonymous memory mapped into user space",
+	"Mapped":          "Files mapped into memory",
+	"Shmem":           "Shared memory and tmpfs",
+	"Slab":            "Kernel slab memory",
+	"SReclaimable":    "Reclaimable kernel slab memory",
+	"SUnreclaim":      "Unreclaimable kernel slab memory",
+	"KernelStack":     "Kernel stacks",
+	"PageTables":      "Page tables",
+	"SwapTotal":       "Total swap space",
+	"SwapFree":        "Free swap space",
+	"CommitLimit":     "Memory that can be allocated under strict overcommit",
+	"Committed_AS":    "Memory allocated by processes",
+	"AnonHugePages":   "Anonymous memory in transparent huge pages",
+	"HugePages_Total": "Number of huge pages in the pool",
+	"HugePages_Free":  "Number of huge pages not yet allocated",
+	"HugePages_Rsvd":  "Number of huge pages reserved but not yet allocated",
+	"HugePages_Surp":  "Number of surplus huge pages",
+	"Hugepagesize":    "Size of a huge page",
+}
+
+func parseMemInfoNames(memInfo []keyValue) []string {
+	var names []string
+	for _, value := range memInfo {
+		names = append(names, fmt.Sprintf("mem.%s", value.key))
+	}
+	return names
+}
+
+func parseMemInfoDescriptions(memInfo []keyValue) []string {
+	var descriptions []string
+	for _, value := range memInfo {
+		memInfoDescription, ok := memInfoDescriptions[value.key]
+		if !ok {
+			memInfoDescription = value.key
+		}
+		unit := value.unit
+		if unit == "" && strings.HasPrefix(value.key, "HugePages_") {
+			unit = "pages"
+		}
+		description := fmt.Sprintf("mem.%s = %s", value.key, memInfoDescription)
+		if unit != "" {
+			description = fmt.Sprintf("%s (%s)", description, unit)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+func parseMemInfoUnits(memInfo []keyValue) []Unit {
+	var units []Unit
+	for _, value := range memInfo {
+		unit := Count
+		if value.unit == "kB" {
+			unit = Kilobytes
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+func parseMemInfo(memInfo []keyValue, keys []string) []float64 {
+	values := keyValueMap(memInfo)
+	var result []float64
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			result = append(result, math.NaN())
+			continue
+		}
+		result = append(result, float64(value))
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "meminfo")
+	writeFile(t, path, `MemTotal:       16314348 kB
+MemFree:         8123456 kB
+HugePages_Total:       0
+`)
+	stat, err := NewMemInfoStat(WithProcRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mem.MemTotal", "mem.MemFree", "mem.HugePages_Total"}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	wantKinds := []Kind{Gauge, Gauge, Gauge}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	wantUnits := []Unit{Kilobytes, Kilobytes, Count}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	wantDescriptions := []string{
+		"mem.MemTotal = Total usable memory (kB)",
+		"mem.MemFree = Free memory (kB)",
+		"mem.HugePages_Total = Number of huge pages in the pool (pages)",
+	}
+	if !reflect.DeepEqual(stat.Descriptions, wantDescriptions) {
+		t.Errorf("Descriptions = %v, want %v", stat.Descriptions, wantDescriptions)
+	}
+	writeFile(t, path, `MemTotal:       16314348 kB
+MemFree:         8000000 kB
+HugePages_Total:       4
+`)
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{16314348, 8000000, 4}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Collect() = %v, want %v", values, wantValues)
+	}
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
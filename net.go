@@ -6,36 +6,78 @@ import (
 )
 
 type procNetDevCollector struct {
-	values []uint64
+	path     string
+	opts     *options
+	stats    []procfs.NetworkStat
+	names    []string
+	counters *counterSet
 }
 
-const procNetDevPath = "/proc/net/dev"
+const procNetDevPath = "net/dev"
 
 // NewNetStat returns a new Stat, which collects networking stats from /proc/net/dev.
-func NewNetStat() *Stat {
-	stats, err := procfs.ReadNetworkStat(procNetDevPath)
+func NewNetStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	path := o.procPath(procNetDevPath)
+	stats, err := readNetStats(path, o)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	names := parseNetStatNames(stats)
-	descriptions := parseNetStatDescriptions(stats)
-	values := parseNetStats(stats)
-	return &Stat{
-		Names:        names,
-		Descriptions: descriptions,
-		Collector:    &procNetDevCollector{values: values},
+	reader := &procNetDevCollector{
+		path:     path,
+		opts:     o,
+		stats:    stats,
+		names:    names,
+		counters: newCounterSet(names, parseNetStats(stats), o),
 	}
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
 }
 
-func (reader *procNetDevCollector) Collect() []uint64 {
-	stats, err := procfs.ReadNetworkStat(procNetDevPath)
+func (reader *procNetDevCollector) Collect() ([]float64, error) {
+	stats, err := readNetStats(reader.path, reader.opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	values := parseNetStats(stats)
-	diff := Difference(reader.values, values)
-	reader.values = values
-	return diff
+	reader.stats = stats
+	return reader.counters.update(reader.names, parseNetStatNames(stats), parseNetStats(stats)), nil
+}
+
+// UpdateColumns updates stat to match the network interfaces in the last sample.
+func (reader *procNetDevCollector) UpdateColumns(stat *Stat) bool {
+	names := parseNetStatNames(reader.stats)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.describe(stat)
+	return true
+}
+
+func (reader *procNetDevCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = parseNetStatDescriptions(reader.stats, reader.opts)
+	stat.Kinds = kinds(Counter, len(reader.names))
+	stat.Units = parseNetStatUnits(reader.stats, reader.opts)
+	stat.Sources = sources(reader.path, len(reader.names))
+}
+
+// readNetStats reads the stats of the network interfaces that are selected by
+// the options.
+func readNetStats(path string, o *options) ([]procfs.NetworkStat, error) {
+	stats, err := procfs.ReadNetworkStat(path)
+	if err != nil {
+		return nil, err
+	}
+	var result []procfs.NetworkStat
+	for _, stat := range stats {
+		if o.interfaces.matches(stat.Iface) {
+			result = append(result, stat)
+		}
+	}
+	return result, nil
 }
 
 var netStatTypes = []string{
@@ -43,21 +85,70 @@ var netStatTypes = []string{
 	"rx.packets",
 	"rx.errors",
 	"rx.drop",
+	"rx.fifo",
+	"rx.frame",
+	"rx.compressed",
+	"rx.multicast",
 	"tx.bytes",
 	"tx.packets",
 	"tx.errors",
 	"tx.drop",
+	"tx.fifo",
+	"tx.colls",
+	"tx.carrier",
+	"tx.compressed",
 }
 
 var netStatDescriptions = map[string]string{
-	"rx.bytes":   "Number of bytes received",
-	"rx.packets": "Number of packets received",
-	"rx.errors":  "Number of receive errors",
-	"rx.drop":    "Number of receive packets dropped",
-	"tx.bytes":   "Number of bytes transmitd",
-	"tx.packets": "Number of packets transmitd",
-	"tx.errors":  "Number of transmit errors",
-	"tx.drop":    "Number of transmit packets dropped",
+	"rx.bytes":      "Number of bytes received",
+	"rx.packets":    "Number of packets received",
+	"rx.errors":     "Number of receive errors",
+	"rx.drop":       "Number of receive packets dropped",
+	"rx.fifo":       "Number of receive FIFO buffer errors",
+	"rx.frame":      "Number of receive packet framing errors",
+	"rx.compressed": "Number of compressed packets received",
+	"rx.multicast":  "Number of multicast packets received",
+	"tx.bytes":      "Number of bytes transmitted",
+	"tx.packets":    "Number of packets transmitted",
+	"tx.errors":     "Number of transmit errors",
+	"tx.drop":       "Number of transmit packets dropped",
+	"tx.fifo":       "Number of transmit FIFO buffer errors",
+	"tx.colls":      "Number of collisions",
+	"tx.carrier":    "Number of carrier losses",
+	"tx.compressed": "Number of compressed packets transmitted",
+}
+
+var netStatUnits = map[string]string{
+	"rx.bytes":      "bytes",
+	"rx.packets":    "packets",
+	"rx.errors":     "errors",
+	"rx.drop":       "packets",
+	"rx.fifo":       "errors",
+	"rx.frame":      "errors",
+	"rx.compressed": "packets",
+	"rx.multicast":  "packets",
+	"tx.bytes":      "bytes",
+	"tx.packets":    "packets",
+	"tx.errors":     "errors",
+	"tx.drop":       "packets",
+	"tx.fifo":       "errors",
+	"tx.colls":      "collisions",
+	"tx.carrier":    "errors",
+	"tx.compressed": "packets",
+}
+
+func parseNetStatUnits(stats []procfs.NetworkStat, o *options) []Unit {
+	var units []Unit
+	for _ = range stats {
+		for _, netStatType := range netStatTypes {
+			unit := Count
+			if netStatUnits[netStatType] == "bytes" {
+				unit = Bytes
+			}
+			units = append(units, o.counterUnit(unit))
+		}
+	}
+	return units
 }
 
 func parseNetStatNames(stats []procfs.NetworkStat) []string {
@@ -71,12 +162,13 @@ func parseNetStatNames(stats []procfs.NetworkStat) []string {
 	return names
 }
 
-func parseNetStatDescriptions(stats []procfs.NetworkStat) []string {
+func parseNetStatDescriptions(stats []procfs.NetworkStat, o *options) []string {
 	var descriptions []string
 	for _, stat := range stats {
 		for _, netStatType := range netStatTypes {
 			netStatDescription := netStatDescriptions[netStatType]
-			description := fmt.Sprintf("net.%s.%s = %s %s", stat.Iface, netStatType, stat.Iface, netStatDescription)
+			unit := o.unit(netStatUnits[netStatType])
+			description := fmt.Sprintf("net.%s.%s = %s %s (%s)", stat.Iface, netStatType, stat.Iface, netStatDescription, unit)
 			descriptions = append(descriptions, description)
 		}
 	}
@@ -90,10 +182,18 @@ func parseNetStats(stats []procfs.NetworkStat) []uint64 {
 		values = append(values, stat.RxPackets)
 		values = append(values, stat.RxErrs)
 		values = append(values, stat.RxDrop)
+		values = append(values, stat.RxFifo)
+		values = append(values, stat.RxFrame)
+		values = append(values, stat.RxCompressed)
+		values = append(values, stat.RxMulticast)
 		values = append(values, stat.TxBytes)
 		values = append(values, stat.TxPackets)
 		values = append(values, stat.TxErrs)
 		values = append(values, stat.TxDrop)
+		values = append(values, stat.TxFifo)
+		values = append(values, stat.TxColls)
+		values = append(values, stat.TxCarrier)
+		values = append(values, stat.TxCompressed)
 	}
 	return values
 }
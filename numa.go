@@ -0,0 +1,166 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type numaCollector struct {
+	dir          string
+	nodes        []string
+	columns      []numaColumn
+	counterNames []string
+	counters     *counterSet
+}
+
+// A numaColumn is a column of a NUMA node stat, which is either a counter
+// from numastat or a gauge from meminfo.
+type numaColumn struct {
+	name string
+	kind Kind
+}
+
+const sysNodePath = "devices/system/node"
+
+var numaStatDescriptions = map[string]string{
+	"numa_hit":       "Memory allocated on the intended node",
+	"numa_miss":      "Memory allocated on the node despite the process preferring another node",
+	"numa_foreign":   "Memory intended for the node but allocated on another node",
+	"interleave_hit": "Interleaved memory allocated on the intended node",
+	"local_node":     "Memory allocated on the node while a process was running on it",
+	"other_node":     "Memory allocated on the node while a process was running on another node",
+}
+
+// NewNUMAStat returns a new Stat, which collects the memory allocation
+// counters and the memory stats of each NUMA node from
+// /sys/devices/system/node/node<N>/numastat and meminfo. The counters are
+// named after the node and the field, such as "numa.node0.numa_miss", and
+// the memory stats like "numa.node0.mem.MemFree". An error is returned if
+// the kernel does not support NUMA.
+func NewNUMAStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	dir := o.sysPath(sysNodePath)
+	ids, err := readNumberedEntries(dir, "node")
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s: NUMA is not supported", dir)
+	}
+	reader := &numaCollector{dir: dir}
+	stat := &Stat{Collector: reader}
+	var counterValues []uint64
+	for _, id := range ids {
+		node := fmt.Sprintf("node%d", id)
+		reader.nodes = append(reader.nodes, node)
+		path := filepath.Join(dir, node, "numastat")
+		numaStats, err := readKeyValues(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, numaStat := range numaStats {
+			name := fmt.Sprintf("numa.%s.%s", node, numaStat.key)
+			description, ok := numaStatDescriptions[numaStat.key]
+			if !ok {
+				description = numaStat.key
+			}
+			reader.columns = append(reader.columns, numaColumn{name: name, kind: Counter})
+			reader.counterNames = append(reader.counterNames, name)
+			counterValues = append(counterValues, numaStat.value)
+			stat.Names = append(stat.Names, name)
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s (%s)", name, description, o.unit("pages")))
+			stat.Kinds = append(stat.Kinds, Counter)
+			stat.Units = append(stat.Units, o.counterUnit(Pages))
+			stat.Sources = append(stat.Sources, path)
+		}
+		path = filepath.Join(dir, node, "meminfo")
+		memInfo, err := readNodeMemInfo(path)
+		if err != nil {
+			return nil, err
+		}
+		descriptions := parseMemInfoDescriptions(memInfo)
+		for idx, unit := range parseMemInfoUnits(memInfo) {
+			name := fmt.Sprintf("numa.%s.mem.%s", node, memInfo[idx].key)
+			reader.columns = append(reader.columns, numaColumn{name: name, kind: Gauge})
+			stat.Names = append(stat.Names, name)
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("numa.%s.%s", node, descriptions[idx]))
+			stat.Kinds = append(stat.Kinds, Gauge)
+			stat.Units = append(stat.Units, unit)
+			stat.Sources = append(stat.Sources, path)
+		}
+	}
+	reader.counters = newCounterSet(reader.counterNames, counterValues, o)
+	return stat, nil
+}
+
+func (reader *numaCollector) Collect() ([]float64, error) {
+	var counterNames []string
+	var counterValues []uint64
+	gauges := map[string]uint64{}
+	for _, node := range reader.nodes {
+		numaStats, err := readKeyValues(filepath.Join(reader.dir, node, "numastat"))
+		if err != nil {
+			return nil, err
+		}
+		for _, numaStat := range numaStats {
+			counterNames = append(counterNames, fmt.Sprintf("numa.%s.%s", node, numaStat.key))
+			counterValues = append(counterValues, numaStat.value)
+		}
+		memInfo, err := readNodeMemInfo(filepath.Join(reader.dir, node, "meminfo"))
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range memInfo {
+			gauges[fmt.Sprintf("numa.%s.mem.%s", node, value.key)] = value.value
+		}
+	}
+	diff := reader.counters.update(reader.counterNames, counterNames, counterValues)
+	var values []float64
+	for _, column := range reader.columns {
+		if column.kind == Counter {
+			values = append(values, diff[0])
+			diff = diff[1:]
+			continue
+		}
+		value, ok := gauges[column.name]
+		if !ok {
+			values = append(values, math.NaN())
+			continue
+		}
+		values = append(values, float64(value))
+	}
+	return values, nil
+}
+
+// readNodeMemInfo reads the meminfo file of a NUMA node, which is like
+// /proc/meminfo, but has the node on each line, such as:
+//
+//	Node 0 MemTotal:       16314348 kB
+func readNodeMemInfo(path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		key := strings.TrimSuffix(fields[2], ":")
+		value, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+		}
+		keyValue := keyValue{key: key, value: value}
+		if len(fields) > 4 {
+			keyValue.unit = fields[4]
+		}
+		values = append(values, keyValue)
+	}
+	return values, nil
+}
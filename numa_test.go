@@ -0,0 +1,55 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNUMAStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	node := filepath.Join(dir, "devices/system/node/node0")
+	writeFile(t, filepath.Join(node, "numastat"), "numa_hit 1000\nnuma_miss 10\n")
+	writeFile(t, filepath.Join(node, "meminfo"), "Node 0 MemTotal:       16314348 kB\nNode 0 MemFree:         8000000 kB\nNode 0 HugePages_Total:     0\n")
+	stat, err := NewNUMAStat(WithSysRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames := []string{
+		"numa.node0.numa_hit",
+		"numa.node0.numa_miss",
+		"numa.node0.mem.MemTotal",
+		"numa.node0.mem.MemFree",
+		"numa.node0.mem.HugePages_Total",
+	}
+	if !reflect.DeepEqual(stat.Names, wantNames) {
+		t.Fatalf("Names = %v, want %v", stat.Names, wantNames)
+	}
+	wantKinds := []Kind{Counter, Counter, Gauge, Gauge, Gauge}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	wantUnits := []Unit{Pages, Pages, Kilobytes, Kilobytes, Count}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	if len(stat.Descriptions) != len(wantNames) || len(stat.Sources) != len(wantNames) {
+		t.Errorf("Descriptions = %v, Sources = %v", stat.Descriptions, stat.Sources)
+	}
+	writeFile(t, filepath.Join(node, "numastat"), "numa_hit 1500\nnuma_miss 12\n")
+	writeFile(t, filepath.Join(node, "meminfo"), "Node 0 MemTotal:       16314348 kB\nNode 0 MemFree:         7000000 kB\nNode 0 HugePages_Total:     0\n")
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{500, 2, 16314348, 7000000, 0}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Collect() = %v, want %v", values, wantValues)
+	}
+}
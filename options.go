@@ -0,0 +1,238 @@
+package ustat
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	defaultProcRoot     = "/proc"
+	defaultSysRoot      = "/sys"
+	cgroupRoot          = "fs/cgroup"
+	defaultCounterWidth = 64
+)
+
+// An Option configures a stats collector.
+type Option func(*options)
+
+type options struct {
+	procRoot     string
+	sysRoot      string
+	cgroups      []string
+	disks        nameFilter
+	wholeDisks   bool
+	dmNames      bool
+	interfaces   nameFilter
+	snmp         nameFilter
+	pids         []int
+	pidFile      string
+	processName  *regexp.Regexp
+	threads      bool
+	rate         bool
+	counterWidth uint
+}
+
+// WithProcRoot returns an Option, which makes collectors read procfs files
+// under root instead of /proc. This is useful for monitoring a host from a
+// container that has the host's /proc mounted elsewhere, or for reading a
+// snapshot of /proc captured from another machine.
+func WithProcRoot(root string) Option {
+	return func(opts *options) {
+		opts.procRoot = root
+	}
+}
+
+// WithSysRoot returns an Option, which makes collectors read sysfs files
+// under root instead of /sys.
+func WithSysRoot(root string) Option {
+	return func(opts *options) {
+		opts.sysRoot = root
+	}
+}
+
+// WithCgroup returns an Option, which makes collectors that support cgroups
+// collect stats of the cgroups at path, such as "/system.slice/foo.service".
+// The path is relative to the cgroup hierarchy mounted at /sys/fs/cgroup and
+// can be a glob pattern such as "/system.slice/*.service". The option can be
+// given more than once.
+func WithCgroup(path string) Option {
+	return func(opts *options) {
+		opts.cgroups = append(opts.cgroups, path)
+	}
+}
+
+// WithDisks returns an Option, which makes the disk collector collect stats
+// only for disks whose names match one of the include glob patterns, such as
+// "sd*", and none of the exclude patterns. If include is empty, all disks
+// that do not match an exclude pattern are collected.
+func WithDisks(include []string, exclude []string) Option {
+	return func(opts *options) {
+		opts.disks = nameFilter{include: include, exclude: exclude}
+	}
+}
+
+// WithWholeDisks returns an Option, which makes the disk collector skip
+// partitions. A device is a whole disk if it has an entry in /sys/block.
+func WithWholeDisks() Option {
+	return func(opts *options) {
+		opts.wholeDisks = true
+	}
+}
+
+// WithDeviceMapperNames returns an Option, which makes the disk collector
+// report device-mapper devices such as "dm-3" by their names in
+// /sys/block/*/dm/name, such as "vg0-root".
+func WithDeviceMapperNames() Option {
+	return func(opts *options) {
+		opts.dmNames = true
+	}
+}
+
+// WithInterfaces returns an Option, which makes the network collector collect
+// stats only for interfaces whose names match one of the include glob
+// patterns, such as "eth*", and none of the exclude patterns. If include is
+// empty, all interfaces that do not match an exclude pattern are collected.
+func WithInterfaces(include []string, exclude []string) Option {
+	return func(opts *options) {
+		opts.interfaces = nameFilter{include: include, exclude: exclude}
+	}
+}
+
+// WithSNMP returns an Option, which makes the network protocol collector
+// collect only fields whose names match one of the include glob patterns,
+// such as "tcp.*" or "tcpext.Listen*", and none of the exclude patterns. If
+// include is empty, all fields that do not match an exclude pattern are
+// collected.
+func WithSNMP(include []string, exclude []string) Option {
+	return func(opts *options) {
+		opts.snmp = nameFilter{include: include, exclude: exclude}
+	}
+}
+
+// WithPIDs returns an Option, which makes the process and scheduler
+// collectors collect stats of the processes with the given PIDs.
+func WithPIDs(pids ...int) Option {
+	return func(opts *options) {
+		opts.pids = append(opts.pids, pids...)
+	}
+}
+
+// WithPIDFile returns an Option, which makes the process and scheduler
+// collectors collect stats of the process whose PID is in the file at path.
+// The file is read on every sample, so a restarted process is followed.
+func WithPIDFile(path string) Option {
+	return func(opts *options) {
+		opts.pidFile = path
+	}
+}
+
+// WithProcessName returns an Option, which makes the process and scheduler
+// collectors collect stats of the processes whose command names, as in
+// /proc/<pid>/comm, match pattern.
+func WithProcessName(pattern *regexp.Regexp) Option {
+	return func(opts *options) {
+		opts.processName = pattern
+	}
+}
+
+// WithThreads returns an Option, which makes the process and scheduler
+// collectors also collect stats of each thread of the selected processes.
+func WithThreads() Option {
+	return func(opts *options) {
+		opts.threads = true
+	}
+}
+
+// WithRate returns an Option, which makes collectors report the change in
+// counters per second instead of per sampling interval. The rate is based on
+// the time measured between samples rather than the nominal sampling delay,
+// so values remain comparable across recordings with different delays.
+func WithRate() Option {
+	return func(opts *options) {
+		opts.rate = true
+	}
+}
+
+// WithCounterWidth returns an Option, which makes collectors treat counters
+// as width bits wide when detecting wraparound. For example, some older
+// kernels report 32-bit counters in /proc/net/dev.
+func WithCounterWidth(width uint) Option {
+	return func(opts *options) {
+		opts.counterWidth = width
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		procRoot:     defaultProcRoot,
+		sysRoot:      defaultSysRoot,
+		counterWidth: defaultCounterWidth,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// A nameFilter selects entities, such as disks, by name with glob patterns.
+type nameFilter struct {
+	include []string
+	exclude []string
+}
+
+// matches returns true if one of names matches the filter. Entities can have
+// more than one name, such as the kernel name and a friendly name of a
+// device-mapper device.
+func (filter nameFilter) matches(names ...string) bool {
+	for _, name := range names {
+		if matchesAny(filter.exclude, name) {
+			return false
+		}
+	}
+	if len(filter.include) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if matchesAny(filter.include, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// procPath returns the path of a procfs file relative to the procfs root.
+func (o *options) procPath(name string) string {
+	return filepath.Join(o.procRoot, name)
+}
+
+// sysPath returns the path of a sysfs file relative to the sysfs root.
+func (o *options) sysPath(name string) string {
+	return filepath.Join(o.sysRoot, name)
+}
+
+// unit returns the unit of a counter, which is either per sampling interval,
+// or per second if rate normalization is enabled.
+func (o *options) unit(unit string) string {
+	if o.rate {
+		return unit + "/s"
+	}
+	return unit + "/interval"
+}
+
+// counterUnit returns the unit of counter columns, which is the unit per
+// second if rate normalization is enabled.
+func (o *options) counterUnit(unit Unit) Unit {
+	if o.rate {
+		return unit + "/s"
+	}
+	return unit
+}
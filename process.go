@@ -0,0 +1,405 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the number of clock ticks per second, USER_HZ, in which the
+// kernel reports CPU time in /proc/<pid>/stat. It is 100 on all common
+// architectures, but not on all that Linux supports; on alpha it is 1024.
+const clockTicks = 100
+
+type processCollector struct {
+	opts      *options
+	processes []process
+	names     []string
+	columns   []processColumn
+	counters  *counterSet
+}
+
+// A process is a process or a thread that is followed by the collector.
+type process struct {
+	prefix  string
+	dir     string
+	pid     int
+	tid     int
+	comm    string
+	values  []uint64
+	present []bool
+}
+
+// A processColumn is a column of a process stat.
+type processColumn struct {
+	process  process
+	statType string
+}
+
+// NewProcessStat returns a new Stat, which collects stats of the processes
+// selected with WithPIDs, WithPIDFile and WithProcessName from
+// /proc/<pid>/stat, status and io, and of their threads from
+// /proc/<pid>/task if WithThreads is given. The processes are selected again
+// on every sample, so exited processes are dropped and new matches are
+// picked up during a recording.
+func NewProcessStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	reader := &processCollector{opts: o}
+	processes, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	names, values := parseProcessStats(processes)
+	reader.processes = processes
+	reader.names, reader.columns = parseProcessColumns(processes)
+	reader.counters = newCounterSet(names, values, o)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+func (reader *processCollector) Collect() ([]float64, error) {
+	processes, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	reader.processes = processes
+	names, values := parseProcessStats(processes)
+	deltas, elapsed := reader.counters.deltas(names, values)
+	current := counterMap(names, values)
+	var result []float64
+	for idx, name := range reader.names {
+		switch processStatKind(reader.columns[idx].statType) {
+		case Gauge:
+			value, ok := current[name]
+			if !ok {
+				result = append(result, math.NaN())
+				continue
+			}
+			result = append(result, float64(value))
+		case Ratio:
+			if elapsed <= 0 {
+				result = append(result, math.NaN())
+				continue
+			}
+			result = append(result, deltas.value(name)/clockTicks/elapsed.Seconds()*100)
+		default:
+			result = append(result, reader.counters.normalize(deltas.value(name), elapsed))
+		}
+	}
+	return result, nil
+}
+
+// UpdateColumns updates stat to match the processes in the last sample.
+func (reader *processCollector) UpdateColumns(stat *Stat) bool {
+	names, columns := parseProcessColumns(reader.processes)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.columns = columns
+	reader.describe(stat)
+	return true
+}
+
+func (reader *processCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	stat.Sources = nil
+	for idx, column := range reader.columns {
+		kind := processStatKind(column.statType)
+		unit := processStatUnits[column.statType]
+		description := string(unit)
+		if kind == Counter {
+			description = reader.opts.unit(description)
+			unit = reader.opts.counterUnit(unit)
+		}
+		entity := fmt.Sprintf("%d (%s)", column.process.pid, column.process.comm)
+		if column.process.tid != 0 {
+			entity = fmt.Sprintf("%d thread %d (%s)", column.process.pid, column.process.tid, column.process.comm)
+		}
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s %s (%s)", reader.names[idx], entity, processStatDescriptions[column.statType], description))
+		stat.Kinds = append(stat.Kinds, kind)
+		stat.Units = append(stat.Units, unit)
+		stat.Sources = append(stat.Sources, filepath.Join(column.process.dir, processStatSources[column.statType]))
+	}
+}
+
+var processStatTypes = []string{
+	"utime",
+	"stime",
+	"minflt",
+	"majflt",
+	"rss",
+	"threads",
+	"ctxt.voluntary",
+	"ctxt.involuntary",
+	"io.rchar",
+	"io.wchar",
+	"io.read.bytes",
+	"io.write.bytes",
+}
+
+var processStatDescriptions = map[string]string{
+	"utime":            "User CPU time",
+	"stime":            "System CPU time",
+	"minflt":           "Number of minor page faults",
+	"majflt":           "Number of major page faults",
+	"rss":              "Resident set size",
+	"threads":          "Number of threads",
+	"ctxt.voluntary":   "Number of voluntary context switches",
+	"ctxt.involuntary": "Number of involuntary context switches",
+	"io.rchar":         "Number of bytes read",
+	"io.wchar":         "Number of bytes written",
+	"io.read.bytes":    "Number of bytes read from storage",
+	"io.write.bytes":   "Number of bytes written to storage",
+}
+
+var processStatUnits = map[string]Unit{
+	"utime":            Percent,
+	"stime":            Percent,
+	"minflt":           Count,
+	"majflt":           Count,
+	"rss":              Kilobytes,
+	"threads":          Count,
+	"ctxt.voluntary":   Count,
+	"ctxt.involuntary": Count,
+	"io.rchar":         Bytes,
+	"io.wchar":         Bytes,
+	"io.read.bytes":    Bytes,
+	"io.write.bytes":   Bytes,
+}
+
+var processStatSources = map[string]string{
+	"utime":            "stat",
+	"stime":            "stat",
+	"minflt":           "stat",
+	"majflt":           "stat",
+	"rss":              "status",
+	"threads":          "status",
+	"ctxt.voluntary":   "status",
+	"ctxt.involuntary": "status",
+	"io.rchar":         "io",
+	"io.wchar":         "io",
+	"io.read.bytes":    "io",
+	"io.write.bytes":   "io",
+}
+
+func processStatKind(statType string) Kind {
+	switch statType {
+	case "utime", "stime":
+		return Ratio
+	case "rss", "threads":
+		return Gauge
+	}
+	return Counter
+}
+
+// read reads the stats of the selected processes and, if enabled, of their
+// threads. Processes that exit while they are being read are skipped.
+func (reader *processCollector) read() ([]process, error) {
+	pids, err := selectProcesses(reader.opts)
+	if err != nil {
+		return nil, err
+	}
+	var processes []process
+	for _, pid := range pids {
+		dir := reader.opts.procPath(strconv.Itoa(pid))
+		proc, ok := readProcess(dir, fmt.Sprintf("proc.%d", pid))
+		if !ok {
+			continue
+		}
+		proc.pid = pid
+		processes = append(processes, proc)
+		if !reader.opts.threads {
+			continue
+		}
+		for _, tid := range readPIDs(filepath.Join(dir, "task")) {
+			thread, ok := readProcess(filepath.Join(dir, "task", strconv.Itoa(tid)), fmt.Sprintf("proc.%d.task.%d", pid, tid))
+			if !ok {
+				continue
+			}
+			thread.pid = pid
+			thread.tid = tid
+			processes = append(processes, thread)
+		}
+	}
+	return processes, nil
+}
+
+// selectProcesses returns the PIDs of the processes that are selected by PID,
+// by a PID file or by process name, in ascending order.
+func selectProcesses(o *options) ([]int, error) {
+	selected := map[int]bool{}
+	for _, pid := range o.pids {
+		selected[pid] = true
+	}
+	if o.pidFile != "" {
+		data, err := ioutil.ReadFile(o.pidFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid PID: %v", o.pidFile, err)
+			}
+			selected[pid] = true
+		}
+	}
+	if o.processName != nil {
+		for _, pid := range readPIDs(o.procRoot) {
+			if matchesProcessName(o.processName, o.procPath(strconv.Itoa(pid))) {
+				selected[pid] = true
+			}
+		}
+	}
+	var pids []int
+	for pid := range selected {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+func matchesProcessName(pattern *regexp.Regexp, dir string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return false
+	}
+	return pattern.MatchString(strings.TrimSpace(string(data)))
+}
+
+// readPIDs returns the numeric entries of a directory, such as /proc or
+// /proc/<pid>/task, in ascending order.
+func readPIDs(dir string) []int {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// readProcess reads the stats of a process or a thread from dir. It returns
+// false if the process does not exist. Stats in /proc/<pid>/io, which is
+// only readable by the owner of the process, are missing if the file cannot
+// be read.
+func readProcess(dir string, prefix string) (process, bool) {
+	proc := process{
+		prefix:  prefix,
+		dir:     dir,
+		values:  make([]uint64, len(processStatTypes)),
+		present: make([]bool, len(processStatTypes)),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return proc, false
+	}
+	stat := string(data)
+	start, end := strings.Index(stat, "("), strings.LastIndex(stat, ")")
+	if start < 0 || end < start {
+		return proc, false
+	}
+	proc.comm = stat[start+1 : end]
+	fields := strings.Fields(stat[end+1:])
+	// The fields after the command name start from the process state, which
+	// is the third field in proc(5).
+	statFields := map[string]int{"minflt": 10, "majflt": 12, "utime": 14, "stime": 15}
+	for statType, field := range statFields {
+		if field-3 < len(fields) {
+			proc.parse(statType, fields[field-3])
+		}
+	}
+	statusFields := map[string]string{
+		"VmRSS":                      "rss",
+		"Threads":                    "threads",
+		"voluntary_ctxt_switches":    "ctxt.voluntary",
+		"nonvoluntary_ctxt_switches": "ctxt.involuntary",
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			if statType, ok := statusFields[strings.TrimSuffix(fields[0], ":")]; ok {
+				proc.parse(statType, fields[1])
+			}
+		}
+	}
+	ioFields := map[string]string{
+		"rchar":       "io.rchar",
+		"wchar":       "io.wchar",
+		"read_bytes":  "io.read.bytes",
+		"write_bytes": "io.write.bytes",
+	}
+	if values, err := readKeyValues(filepath.Join(dir, "io")); err == nil {
+		for _, value := range values {
+			if statType, ok := ioFields[value.key]; ok {
+				proc.set(statType, value.value)
+			}
+		}
+	}
+	return proc, true
+}
+
+func (proc *process) parse(statType string, field string) {
+	value, err := strconv.ParseUint(field, 10, 64)
+	if err == nil {
+		proc.set(statType, value)
+	}
+}
+
+func (proc *process) set(statType string, value uint64) {
+	for idx, processStatType := range processStatTypes {
+		if processStatType == statType {
+			proc.values[idx] = value
+			proc.present[idx] = true
+		}
+	}
+}
+
+func parseProcessColumns(processes []process) ([]string, []processColumn) {
+	var names []string
+	var columns []processColumn
+	for _, proc := range processes {
+		for _, statType := range processStatTypes {
+			names = append(names, fmt.Sprintf("%s.%s", proc.prefix, statType))
+			columns = append(columns, processColumn{process: proc, statType: statType})
+		}
+	}
+	return names, columns
+}
+
+// parseProcessStats returns the names and values of the stats that could be
+// read.
+func parseProcessStats(processes []process) ([]string, []uint64) {
+	var names []string
+	var values []uint64
+	for _, proc := range processes {
+		for idx, statType := range processStatTypes {
+			if !proc.present[idx] {
+				continue
+			}
+			names = append(names, fmt.Sprintf("%s.%s", proc.prefix, statType))
+			values = append(values, proc.values[idx])
+		}
+	}
+	return names, values
+}
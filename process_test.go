@@ -0,0 +1,56 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProcessStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	proc := filepath.Join(dir, "42")
+	writeFile(t, filepath.Join(proc, "stat"), "42 (my app) S 1 42 42 0 -1 4194304 100 0 5 0 200 50 0 0 20 0 2 0 12345\n")
+	writeFile(t, filepath.Join(proc, "status"), "Name:\tmy app\nVmRSS:\t    2048 kB\nThreads:\t2\nvoluntary_ctxt_switches:\t10\nnonvoluntary_ctxt_switches:\t1\n")
+	writeFile(t, filepath.Join(proc, "io"), "rchar: 1000\nwchar: 2000\nread_bytes: 4096\nwrite_bytes: 0\n")
+	stat, err := NewProcessStat(WithProcRoot(dir), WithPIDs(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, statType := range processStatTypes {
+		names = append(names, "proc.42."+statType)
+	}
+	if !reflect.DeepEqual(stat.Names, names) {
+		t.Fatalf("Names = %v, want %v", stat.Names, names)
+	}
+	wantKinds := []Kind{Ratio, Ratio, Counter, Counter, Gauge, Gauge, Counter, Counter, Counter, Counter, Counter, Counter}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	wantUnits := []Unit{Percent, Percent, Count, Count, Kilobytes, Count, Count, Count, Bytes, Bytes, Bytes, Bytes}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	if len(stat.Descriptions) != len(names) || stat.Sources[4] != filepath.Join(proc, "status") {
+		t.Errorf("Descriptions = %v, Sources = %v", stat.Descriptions, stat.Sources)
+	}
+	writeFile(t, filepath.Join(proc, "stat"), "42 (my app) S 1 42 42 0 -1 4194304 130 0 5 0 250 50 0 0 20 0 2 0 12345\n")
+	writeFile(t, filepath.Join(proc, "status"), "Name:\tmy app\nVmRSS:\t    4096 kB\nThreads:\t3\nvoluntary_ctxt_switches:\t15\nnonvoluntary_ctxt_switches:\t1\n")
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] <= 0 || values[1] != 0 {
+		t.Errorf("utime, stime = %v, %v, want > 0, 0", values[0], values[1])
+	}
+	wantValues := []float64{30, 0, 4096, 3, 5, 0, 0, 0, 0, 0}
+	if !reflect.DeepEqual(values[2:], wantValues) {
+		t.Errorf("Collect()[2:] = %v, want %v", values[2:], wantValues)
+	}
+}
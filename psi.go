@@ -0,0 +1,281 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type psiCollector struct {
+	opts     *options
+	readings []psiReading
+	names    []string
+	kinds    []Kind
+	counters *counterSet
+}
+
+// A psiSource is a file with Pressure Stall Information about a resource.
+type psiSource struct {
+	prefix   string
+	resource string
+	path     string
+}
+
+// A psiReading is the content of the PSI file of a source.
+type psiReading struct {
+	source    psiSource
+	pressures []pressure
+}
+
+// A pressure is one line of a PSI file, which reports the share of time
+// that some or all tasks were stalled on a resource.
+type pressure struct {
+	kind   string
+	avg10  float64
+	avg60  float64
+	avg300 float64
+	total  uint64
+}
+
+const procPressurePath = "pressure"
+
+var psiResources = []string{"cpu", "memory", "io"}
+
+// NewPSIStat returns a new Stat, which collects Pressure Stall Information
+// from /proc/pressure and, if cgroups are selected with WithCgroup, from the
+// pressure files of the cgroups in the cgroup v2 hierarchy. Resources
+// without PSI files are skipped, and an error is returned if the kernel does
+// not support PSI at all.
+func NewPSIStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	reader := &psiCollector{opts: o}
+	readings, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(readings) == 0 {
+		return nil, fmt.Errorf("%s: Pressure Stall Information is not supported", o.procPath(procPressurePath))
+	}
+	totalNames, totals := parsePSITotals(readings)
+	reader.readings = readings
+	reader.names, reader.kinds = parsePSIColumns(readings)
+	reader.counters = newCounterSet(totalNames, totals, o)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+// Collect returns the current pressure averages and the change in the total
+// stall times. The stats of cgroups that were removed since the last sample
+// are reported as NaN.
+func (reader *psiCollector) Collect() ([]float64, error) {
+	readings, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	reader.readings = readings
+	gauges := map[string]float64{}
+	for _, reading := range readings {
+		for _, pressure := range reading.pressures {
+			name := psiStatName(reading.source, pressure)
+			gauges[name+".avg10"] = pressure.avg10
+			gauges[name+".avg60"] = pressure.avg60
+			gauges[name+".avg300"] = pressure.avg300
+		}
+	}
+	totalNames, totals := parsePSITotals(readings)
+	var counterNames []string
+	for idx, name := range reader.names {
+		if reader.kinds[idx] == Counter {
+			counterNames = append(counterNames, name)
+		}
+	}
+	diff := reader.counters.update(counterNames, totalNames, totals)
+	var values []float64
+	for idx, name := range reader.names {
+		if reader.kinds[idx] == Counter {
+			values = append(values, diff[0])
+			diff = diff[1:]
+			continue
+		}
+		value, ok := gauges[name]
+		if !ok {
+			value = math.NaN()
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// UpdateColumns updates stat to match the cgroups in the last sample.
+func (reader *psiCollector) UpdateColumns(stat *Stat) bool {
+	names, kinds := parsePSIColumns(reader.readings)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.kinds = kinds
+	reader.describe(stat)
+	return true
+}
+
+func (reader *psiCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = nil
+	stat.Kinds = reader.kinds
+	stat.Units = nil
+	stat.Sources = nil
+	for _, reading := range reader.readings {
+		source := reading.source
+		for _, pressure := range reading.pressures {
+			name := psiStatName(source, pressure)
+			description := fmt.Sprintf("Time that %s were stalled on %s", psiTasks(pressure.kind), source.resource)
+			for _, avg := range []string{"avg10", "avg60", "avg300"} {
+				stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s.%s = %s, %ss average (%%)", name, avg, description, avg[3:]))
+				stat.Units = append(stat.Units, Percent)
+				stat.Sources = append(stat.Sources, source.path)
+			}
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s.total = %s (%s)", name, description, reader.opts.unit("us")))
+			stat.Units = append(stat.Units, reader.opts.counterUnit(Microseconds))
+			stat.Sources = append(stat.Sources, source.path)
+		}
+	}
+}
+
+// read reads the system-wide PSI files and the PSI files of the selected
+// cgroups, which are selected again on every read. Sources without PSI files
+// are skipped, as are cgroups that are removed while they are being read.
+func (reader *psiCollector) read() ([]psiReading, error) {
+	var sources []psiSource
+	for _, resource := range psiResources {
+		sources = append(sources, psiSource{
+			prefix:   fmt.Sprintf("psi.%s", resource),
+			resource: resource,
+			path:     reader.opts.procPath(procPressurePath + "/" + resource),
+		})
+	}
+	root := cgroupV2Root(reader.opts)
+	for _, cgroup := range selectCgroups(root, reader.opts.cgroups) {
+		for _, resource := range psiResources {
+			sources = append(sources, psiSource{
+				prefix:   fmt.Sprintf("psi.cgroup.%s.%s", cgroupName(cgroup), resource),
+				resource: resource,
+				path:     filepath.Join(root, cgroup, resource+".pressure"),
+			})
+		}
+	}
+	var readings []psiReading
+	for _, source := range sources {
+		pressures, err := readPressure(source.path)
+		if isNotSupported(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, psiReading{source: source, pressures: pressures})
+	}
+	return readings, nil
+}
+
+func psiStatName(source psiSource, pressure pressure) string {
+	return fmt.Sprintf("%s.%s", source.prefix, pressure.kind)
+}
+
+func parsePSIColumns(readings []psiReading) ([]string, []Kind) {
+	var names []string
+	var kinds []Kind
+	for _, reading := range readings {
+		for _, pressure := range reading.pressures {
+			name := psiStatName(reading.source, pressure)
+			for _, avg := range []string{"avg10", "avg60", "avg300"} {
+				names = append(names, fmt.Sprintf("%s.%s", name, avg))
+				kinds = append(kinds, Gauge)
+			}
+			names = append(names, name+".total")
+			kinds = append(kinds, Counter)
+		}
+	}
+	return names, kinds
+}
+
+func parsePSITotals(readings []psiReading) ([]string, []uint64) {
+	var names []string
+	var values []uint64
+	for _, reading := range readings {
+		for _, pressure := range reading.pressures {
+			names = append(names, psiStatName(reading.source, pressure)+".total")
+			values = append(values, pressure.total)
+		}
+	}
+	return names, values
+}
+
+// psiTasks describes the tasks that a line of a PSI file is about.
+func psiTasks(kind string) string {
+	switch kind {
+	case "some":
+		return "some tasks"
+	case "full":
+		return "all non-idle tasks"
+	}
+	return kind + " tasks"
+}
+
+// isNotSupported returns true if err means that a PSI file does not exist or
+// that PSI is disabled, for example with the psi=0 kernel parameter.
+func isNotSupported(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == syscall.EOPNOTSUPP
+	}
+	return false
+}
+
+// readPressure reads a PSI file, which has lines such as:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressure(path string) ([]pressure, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pressures []pressure
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pressure := pressure{kind: fields[0]}
+		for _, field := range fields[1:] {
+			idx := strings.Index(field, "=")
+			if idx < 0 {
+				return nil, fmt.Errorf("%s: invalid field '%s'", path, field)
+			}
+			key, value := field[:idx], field[idx+1:]
+			var err error
+			switch key {
+			case "avg10":
+				pressure.avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				pressure.avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				pressure.avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				pressure.total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+			}
+		}
+		pressures = append(pressures, pressure)
+	}
+	return pressures, nil
+}
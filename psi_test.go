@@ -0,0 +1,57 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPSICgroupRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	procRoot := filepath.Join(dir, "proc")
+	sysRoot := filepath.Join(dir, "sys")
+	writeFile(t, filepath.Join(procRoot, "pressure", "cpu"), "some avg10=1.00 avg60=2.00 avg300=3.00 total=100\n")
+	writeFile(t, filepath.Join(sysRoot, "fs", "cgroup", "cgroup.controllers"), "cpu memory io\n")
+	cgroupPressure := filepath.Join(sysRoot, "fs", "cgroup", "job", "cpu.pressure")
+	writeFile(t, cgroupPressure, "some avg10=4.00 avg60=5.00 avg300=6.00 total=200\n")
+	stat, err := NewPSIStat(WithProcRoot(procRoot), WithSysRoot(sysRoot), WithCgroup("/job"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"psi.cpu.some.avg10", "psi.cpu.some.avg60", "psi.cpu.some.avg300", "psi.cpu.some.total",
+		"psi.cgroup.job.cpu.some.avg10", "psi.cgroup.job.cpu.some.avg60", "psi.cgroup.job.cpu.some.avg300", "psi.cgroup.job.cpu.some.total",
+	}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	writeFile(t, filepath.Join(procRoot, "pressure", "cpu"), "some avg10=1.50 avg60=2.00 avg300=3.00 total=150\n")
+	if err := os.RemoveAll(filepath.Dir(cgroupPressure)); err != nil {
+		t.Fatal(err)
+	}
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values[:4], []float64{1.5, 2, 3, 50}) {
+		t.Errorf("Collect()[:4] = %v, want [1.5 2 3 50]", values[:4])
+	}
+	for idx, value := range values[4:] {
+		if !math.IsNaN(value) {
+			t.Errorf("Collect()[%d] = %v, want NaN", idx+4, value)
+		}
+	}
+	if !stat.Collector.(ColumnUpdater).UpdateColumns(stat) {
+		t.Fatal("UpdateColumns() = false, want true")
+	}
+	if !reflect.DeepEqual(stat.Names, want[:4]) {
+		t.Errorf("Names = %v, want %v", stat.Names, want[:4])
+	}
+}
@@ -0,0 +1,341 @@
+package ustat
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type schedStatCollector struct {
+	path     string
+	opts     *options
+	tasks    []schedTask
+	names    []string
+	columns  []schedStatColumn
+	counters *counterSet
+}
+
+// A schedTask is a CPU, a process or a thread with scheduler stats. The
+// values are the time spent running, the time spent waiting on a run queue
+// and the number of timeslices, in the order of schedStatCounters.
+type schedTask struct {
+	prefix string
+	entity string
+	source string
+	values []uint64
+}
+
+// A schedStatColumn is a column of a scheduler stat.
+type schedStatColumn struct {
+	task     schedTask
+	statType string
+}
+
+const (
+	procSchedStatPath     = "schedstat"
+	procSchedStatsSysctl  = "sys/kernel/sched_schedstats"
+	procTaskDelayAcctPath = "sys/kernel/task_delayacct"
+	minSchedStatVersion   = 15
+)
+
+// schedStatCounters are the counters of each CPU, process and thread.
+var schedStatCounters = []string{
+	"run",
+	"wait",
+	"timeslices",
+}
+
+// schedStatTypes are the counters and the stats derived from them.
+var schedStatTypes = []string{
+	"run",
+	"wait",
+	"timeslices",
+	"wait.avg",
+}
+
+var schedStatDescriptions = map[string]string{
+	"run":        "Time spent running",
+	"wait":       "Time spent waiting on a run queue",
+	"timeslices": "Number of timeslices run",
+	"wait.avg":   "Average time spent waiting on a run queue per timeslice",
+}
+
+var schedStatUnits = map[string]Unit{
+	"run":        Nanoseconds,
+	"wait":       Nanoseconds,
+	"timeslices": Count,
+	"wait.avg":   Nanoseconds,
+}
+
+// NewSchedStat returns a new Stat, which collects the time spent running and
+// waiting on the run queue and the number of timeslices of each CPU from
+// /proc/schedstat, and of the processes selected with WithPIDs, WithPIDFile
+// and WithProcessName from /proc/<pid>/schedstat. The stats of a process are
+// those of its main thread; WithThreads adds the stats of each thread. An
+// error is returned if the kernel does not collect scheduler stats, even if
+// processes are selected, because the kernel then does not collect the wait
+// times of processes either. The error names the kernel.sched_schedstats
+// sysctl that enables them.
+func NewSchedStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	if err := checkSchedStats(o); err != nil {
+		return nil, err
+	}
+	reader := &schedStatCollector{
+		path: o.procPath(procSchedStatPath),
+		opts: o,
+	}
+	tasks, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	names, values := parseSchedStatCounters(tasks)
+	reader.tasks = tasks
+	reader.names, reader.columns = parseSchedStatColumns(tasks)
+	reader.counters = newCounterSet(names, values, o)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+// Collect returns the change in the scheduler counters and the average wait
+// time per timeslice in the sampling interval. The average wait time is NaN if
+// no timeslices were run.
+func (reader *schedStatCollector) Collect() ([]float64, error) {
+	tasks, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	reader.tasks = tasks
+	names, values := parseSchedStatCounters(tasks)
+	deltas, elapsed := reader.counters.deltas(names, values)
+	var result []float64
+	for idx, column := range reader.columns {
+		if column.statType == "wait.avg" {
+			wait := deltas.value(schedStatName(column.task.prefix, "wait"))
+			timeslices := deltas.value(schedStatName(column.task.prefix, "timeslices"))
+			if timeslices == 0 {
+				result = append(result, math.NaN())
+				continue
+			}
+			result = append(result, wait/timeslices)
+			continue
+		}
+		result = append(result, reader.counters.normalize(deltas.value(reader.names[idx]), elapsed))
+	}
+	return result, nil
+}
+
+// UpdateColumns updates stat to match the CPUs and processes in the last
+// sample.
+func (reader *schedStatCollector) UpdateColumns(stat *Stat) bool {
+	names, columns := parseSchedStatColumns(reader.tasks)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.columns = columns
+	reader.describe(stat)
+	return true
+}
+
+func (reader *schedStatCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	stat.Sources = nil
+	for idx, column := range reader.columns {
+		unit := schedStatUnits[column.statType]
+		kind := Ratio
+		description := string(unit)
+		if column.statType != "wait.avg" {
+			kind = Counter
+			description = reader.opts.unit(description)
+			unit = reader.opts.counterUnit(unit)
+		}
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s %s (%s)", reader.names[idx], column.task.entity, schedStatDescriptions[column.statType], description))
+		stat.Kinds = append(stat.Kinds, kind)
+		stat.Units = append(stat.Units, unit)
+		stat.Sources = append(stat.Sources, column.task.source)
+	}
+}
+
+// checkSchedStats returns an error if the kernel does not collect the
+// scheduler stats in /proc/schedstat. The file is missing if the kernel is
+// built without CONFIG_SCHEDSTATS. Since Linux 4.6, the run and wait times are
+// only collected if schedstats are enabled with the kernel.sched_schedstats
+// sysctl or if task delay accounting is enabled.
+func checkSchedStats(o *options) error {
+	if _, err := os.Stat(o.procPath(procSchedStatPath)); os.IsNotExist(err) {
+		return fmt.Errorf("%s: scheduler stats are not supported: the kernel is built without CONFIG_SCHEDSTATS", o.procPath(procSchedStatPath))
+	}
+	enabled, err := readUint(o.procPath(procSchedStatsSysctl))
+	if err != nil || enabled != 0 {
+		return nil
+	}
+	// Task delay accounting is enabled by default before Linux 5.14, which
+	// added the kernel.task_delayacct sysctl.
+	delayAcct, err := readUint(o.procPath(procTaskDelayAcctPath))
+	if err != nil || delayAcct != 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: scheduler stats are disabled: enable them with 'sysctl kernel.sched_schedstats=1'", o.procPath(procSchedStatPath))
+}
+
+// read reads the scheduler stats of the CPUs and of the selected processes
+// and, if enabled, of their threads. Processes that exit while they are being
+// read are skipped.
+func (reader *schedStatCollector) read() ([]schedTask, error) {
+	tasks, err := readSchedStat(reader.path)
+	if err != nil {
+		return nil, err
+	}
+	pids, err := selectProcesses(reader.opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range pids {
+		dir := reader.opts.procPath(strconv.Itoa(pid))
+		var comm string
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "comm")); err == nil {
+			comm = strings.TrimSpace(string(data))
+		}
+		task, ok := readTaskSchedStat(filepath.Join(dir, "schedstat"), fmt.Sprintf("proc.%d", pid), fmt.Sprintf("%d (%s)", pid, comm))
+		if !ok {
+			continue
+		}
+		tasks = append(tasks, task)
+		if !reader.opts.threads {
+			continue
+		}
+		for _, tid := range readPIDs(filepath.Join(dir, "task")) {
+			path := filepath.Join(dir, "task", strconv.Itoa(tid), "schedstat")
+			thread, ok := readTaskSchedStat(path, fmt.Sprintf("proc.%d.task.%d", pid, tid), fmt.Sprintf("%d thread %d (%s)", pid, tid, comm))
+			if ok {
+				tasks = append(tasks, thread)
+			}
+		}
+	}
+	return tasks, nil
+}
+
+// readSchedStat reads /proc/schedstat, which starts with a version line,
+// such as:
+//
+//	version 15
+//	timestamp 4295324780
+//	cpu0 0 0 25467 11307 12512 8342 5066380436 1089352637 14155
+//	domain0 00000003 1285 1277 7 ...
+//
+// The seventh to ninth fields of the CPU lines are the time spent running and
+// waiting on the run queue in nanoseconds and the number of timeslices. The
+// format of the CPU lines is the same since version 15.
+func readSchedStat(path string) ([]schedTask, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: missing version", path)
+	}
+	var version int
+	if _, err := fmt.Sscanf(scanner.Text(), "version %d", &version); err != nil {
+		return nil, fmt.Errorf("%s: invalid version line '%s'", path, scanner.Text())
+	}
+	if version < minSchedStatVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", path, version)
+	}
+	var tasks []schedTask
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("%s: invalid line '%s'", path, scanner.Text())
+		}
+		task := schedTask{
+			prefix: fields[0],
+			entity: fields[0],
+			source: path,
+		}
+		for _, field := range fields[7:10] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %v", path, fields[0], err)
+			}
+			task.values = append(task.values, value)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// readTaskSchedStat reads /proc/<pid>/schedstat, which has the time spent
+// running and waiting on a run queue in nanoseconds and the number of
+// timeslices of a task. It returns false if the task does not exist.
+func readTaskSchedStat(path string, prefix string, entity string) (schedTask, bool) {
+	task := schedTask{
+		prefix: prefix,
+		entity: entity,
+		source: path,
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return task, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < len(schedStatCounters) {
+		return task, false
+	}
+	for _, field := range fields[:len(schedStatCounters)] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return task, false
+		}
+		task.values = append(task.values, value)
+	}
+	return task, true
+}
+
+func schedStatName(prefix string, statType string) string {
+	return fmt.Sprintf("schedstat.%s.%s", prefix, statType)
+}
+
+func parseSchedStatColumns(tasks []schedTask) ([]string, []schedStatColumn) {
+	var names []string
+	var columns []schedStatColumn
+	for _, task := range tasks {
+		for _, statType := range schedStatTypes {
+			names = append(names, schedStatName(task.prefix, statType))
+			columns = append(columns, schedStatColumn{task: task, statType: statType})
+		}
+	}
+	return names, columns
+}
+
+func parseSchedStatCounters(tasks []schedTask) ([]string, []uint64) {
+	var names []string
+	var values []uint64
+	for _, task := range tasks {
+		for idx, statType := range schedStatCounters {
+			names = append(names, schedStatName(task.prefix, statType))
+			values = append(values, task.values[idx])
+		}
+	}
+	return names, values
+}
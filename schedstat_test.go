@@ -0,0 +1,75 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchedStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "sys/kernel/sched_schedstats"), "1\n")
+	writeFile(t, filepath.Join(dir, "schedstat"), "version 15\ntimestamp 4295324780\ncpu0 0 0 25467 11307 12512 8342 5000 1000 10\ndomain0 00000003 1285 1277 7\n")
+	writeFile(t, filepath.Join(dir, "42/comm"), "app\n")
+	writeFile(t, filepath.Join(dir, "42/schedstat"), "700 300 5\n")
+	stat, err := NewSchedStat(WithProcRoot(dir), WithPIDs(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, prefix := range []string{"cpu0", "proc.42"} {
+		for _, statType := range schedStatTypes {
+			names = append(names, schedStatName(prefix, statType))
+		}
+	}
+	if !reflect.DeepEqual(stat.Names, names) {
+		t.Fatalf("Names = %v, want %v", stat.Names, names)
+	}
+	wantKinds := []Kind{Counter, Counter, Counter, Ratio, Counter, Counter, Counter, Ratio}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	wantUnits := []Unit{Nanoseconds, Nanoseconds, Count, Nanoseconds, Nanoseconds, Nanoseconds, Count, Nanoseconds}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	if len(stat.Descriptions) != len(names) || stat.Sources[4] != filepath.Join(dir, "42/schedstat") {
+		t.Errorf("Descriptions = %v, Sources = %v", stat.Descriptions, stat.Sources)
+	}
+	writeFile(t, filepath.Join(dir, "schedstat"), "version 15\ntimestamp 4295324880\ncpu0 0 0 25467 11307 12512 8342 6000 1400 14\ndomain0 00000003 1285 1277 7\n")
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{1000, 400, 4, 100, 0, 0, 0}
+	if !reflect.DeepEqual(values[:7], wantValues) {
+		t.Errorf("Collect()[:7] = %v, want %v", values[:7], wantValues)
+	}
+	if !math.IsNaN(values[7]) {
+		t.Errorf("proc.42 wait.avg = %v, want NaN", values[7])
+	}
+}
+
+func TestSchedStatDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "sys/kernel/sched_schedstats"), "0\n")
+	writeFile(t, filepath.Join(dir, "sys/kernel/task_delayacct"), "0\n")
+	writeFile(t, filepath.Join(dir, "schedstat"), "version 15\ntimestamp 4295324780\ncpu0 0 0 0 0 0 0 0 0 0\n")
+	writeFile(t, filepath.Join(dir, "42/schedstat"), "700 0 0\n")
+	_, err = NewSchedStat(WithProcRoot(dir), WithPIDs(42))
+	if err == nil || !strings.Contains(err.Error(), "kernel.sched_schedstats") {
+		t.Errorf("NewSchedStat() error = %v, want error naming kernel.sched_schedstats", err)
+	}
+}
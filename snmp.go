@@ -0,0 +1,224 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type procSNMPCollector struct {
+	paths    []string
+	opts     *options
+	stats    []keyValue
+	keys     []string
+	sources  map[string]string
+	counters *counterSet
+}
+
+const (
+	procSNMPPath    = "net/snmp"
+	procSNMP6Path   = "net/snmp6"
+	procNetStatPath = "net/netstat"
+)
+
+// NewSNMPStat returns a new Stat, which collects network protocol stats from
+// /proc/net/snmp, /proc/net/snmp6 and /proc/net/netstat. Stats are named
+// after the protocol and field, such as "snmp.tcp.RetransSegs", and can be
+// selected with WithSNMP. The snmp6 file is skipped if IPv6 is disabled.
+func NewSNMPStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	reader := &procSNMPCollector{
+		paths: []string{o.procPath(procSNMPPath), o.procPath(procNetStatPath)},
+		opts:  o,
+	}
+	if _, err := os.Stat(o.procPath(procSNMP6Path)); err == nil {
+		reader.paths = append(reader.paths, o.procPath(procSNMP6Path))
+	}
+	stats, sources, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	keys := keyValueKeys(stats)
+	reader.stats = stats
+	reader.keys = keys
+	reader.sources = sources
+	reader.counters = newCounterSet(keys, keyValueValues(stats), o)
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+func (reader *procSNMPCollector) Collect() ([]float64, error) {
+	stats, sources, err := reader.read()
+	if err != nil {
+		return nil, err
+	}
+	reader.stats = stats
+	reader.sources = sources
+	values := reader.counters.update(reader.keys, keyValueKeys(stats), keyValueValues(stats))
+	current := keyValueMap(stats)
+	for idx, key := range reader.keys {
+		if !snmpGauges[key] {
+			continue
+		}
+		value, ok := current[key]
+		if !ok {
+			values[idx] = math.NaN()
+			continue
+		}
+		values[idx] = float64(int64(value))
+	}
+	return values, nil
+}
+
+// UpdateColumns updates stat to match the fields in the last sample, because
+// some fields, such as the ICMP message type counters, only appear after the
+// first message of the type.
+func (reader *procSNMPCollector) UpdateColumns(stat *Stat) bool {
+	keys := keyValueKeys(reader.stats)
+	if equalStrings(keys, reader.keys) {
+		return false
+	}
+	reader.keys = keys
+	reader.describe(stat)
+	return true
+}
+
+func (reader *procSNMPCollector) describe(stat *Stat) {
+	stat.Names = nil
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	stat.Sources = nil
+	for _, key := range reader.keys {
+		name := fmt.Sprintf("snmp.%s", key)
+		stat.Names = append(stat.Names, name)
+		stat.Sources = append(stat.Sources, reader.sources[key])
+		if snmpGauges[key] {
+			stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s", name, key))
+			stat.Kinds = append(stat.Kinds, Gauge)
+			stat.Units = append(stat.Units, Count)
+			continue
+		}
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s (%s)", name, key, reader.opts.unit("events")))
+		stat.Kinds = append(stat.Kinds, Counter)
+		stat.Units = append(stat.Units, reader.opts.counterUnit(Count))
+	}
+}
+
+// snmpGauges are the fields that report a level or a setting rather than a
+// cumulative counter.
+var snmpGauges = map[string]bool{
+	"ip.Forwarding":    true,
+	"ip.DefaultTTL":    true,
+	"tcp.RtoAlgorithm": true,
+	"tcp.RtoMin":       true,
+	"tcp.RtoMax":       true,
+	"tcp.MaxConn":      true,
+	"tcp.CurrEstab":    true,
+}
+
+// read reads the selected fields of the protocol stats files. It also
+// returns the file that each field was read from.
+func (reader *procSNMPCollector) read() ([]keyValue, map[string]string, error) {
+	var result []keyValue
+	sources := map[string]string{}
+	for _, path := range reader.paths {
+		var stats []keyValue
+		var err error
+		if strings.HasSuffix(path, "snmp6") {
+			stats, err = readSNMP6(path)
+		} else {
+			stats, err = readSNMP(path)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, stat := range stats {
+			if !reader.opts.snmp.matches(stat.key) {
+				continue
+			}
+			result = append(result, stat)
+			sources[stat.key] = path
+		}
+	}
+	return result, sources, nil
+}
+
+// readSNMP reads a file, which has pairs of lines with the field names and
+// the values of a protocol, such as:
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ...
+//	Tcp: 1 200 120000 -1 ...
+//
+// The keys are the lower-case protocol and the field name, such as
+// "tcp.RtoMin".
+func readSNMP(path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var values []keyValue
+	for idx := 0; idx+1 < len(lines); idx += 2 {
+		names, fields := strings.Fields(lines[idx]), strings.Fields(lines[idx+1])
+		if len(names) == 0 || len(names) != len(fields) || names[0] != fields[0] {
+			return nil, fmt.Errorf("%s: mismatched lines '%s' and '%s'", path, lines[idx], lines[idx+1])
+		}
+		protocol := strings.ToLower(strings.TrimSuffix(names[0], ":"))
+		for fieldIdx := 1; fieldIdx < len(names); fieldIdx++ {
+			key := fmt.Sprintf("%s.%s", protocol, names[fieldIdx])
+			value, err := parseSNMPValue(fields[fieldIdx])
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+			}
+			values = append(values, keyValue{key: key, value: value})
+		}
+	}
+	return values, nil
+}
+
+// readSNMP6 reads /proc/net/snmp6, which has a field name that is prefixed
+// with the protocol, such as "Ip6InReceives", and a value on each line. The
+// keys are the lower-case protocol and the field name, such as
+// "ip6.InReceives".
+func readSNMP6(path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		key := fields[0]
+		if idx := strings.Index(key, "6"); idx >= 0 {
+			key = fmt.Sprintf("%s.%s", strings.ToLower(key[:idx+1]), key[idx+1:])
+		}
+		value, err := parseSNMPValue(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+		}
+		values = append(values, keyValue{key: key, value: value})
+	}
+	return values, nil
+}
+
+// parseSNMPValue parses a value of a protocol stat. Some settings, such as
+// Tcp MaxConn, are negative; they are stored in two's complement.
+func parseSNMPValue(field string) (uint64, error) {
+	value, err := strconv.ParseUint(field, 10, 64)
+	if err == nil {
+		return value, nil
+	}
+	signed, signedErr := strconv.ParseInt(field, 10, 64)
+	if signedErr != nil {
+		return 0, err
+	}
+	return uint64(signed), nil
+}
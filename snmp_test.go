@@ -0,0 +1,55 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSNMP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snmp := filepath.Join(dir, "net", "snmp")
+	writeFile(t, snmp, `Tcp: MaxConn CurrEstab RetransSegs
+Tcp: -1 5 100
+`)
+	writeFile(t, filepath.Join(dir, "net", "netstat"), `TcpExt: ListenDrops ListenOverflows
+TcpExt: 3 4
+`)
+	writeFile(t, filepath.Join(dir, "net", "snmp6"), "Ip6InReceives                   	1000\n")
+	stat, err := NewSNMPStat(WithProcRoot(dir), WithSNMP(nil, []string{"tcpext.ListenOverflows"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"snmp.tcp.MaxConn", "snmp.tcp.CurrEstab", "snmp.tcp.RetransSegs", "snmp.tcpext.ListenDrops", "snmp.ip6.InReceives"}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	wantKinds := []Kind{Gauge, Gauge, Counter, Counter, Counter}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	wantUnits := []Unit{Count, Count, Count, Count, Count}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	if len(stat.Descriptions) != len(want) || stat.Sources[0] != snmp || stat.Sources[4] != filepath.Join(dir, "net", "snmp6") {
+		t.Errorf("Descriptions = %v, Sources = %v", stat.Descriptions, stat.Sources)
+	}
+	writeFile(t, snmp, `Tcp: MaxConn CurrEstab RetransSegs
+Tcp: -1 7 130
+`)
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{-1, 7, 30, 0, 0}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Collect() = %v, want %v", values, wantValues)
+	}
+}
@@ -0,0 +1,218 @@
+package ustat
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type procSockStatCollector struct {
+	paths    []string
+	tcpPaths []string
+	keys     []string
+}
+
+const (
+	procSockStatPath  = "net/sockstat"
+	procSockStat6Path = "net/sockstat6"
+	procTCPPath       = "net/tcp"
+	procTCP6Path      = "net/tcp6"
+)
+
+// tcpStates are the TCP connection states in /proc/net/tcp, indexed by the
+// state number.
+var tcpStates = []string{
+	"",
+	"established",
+	"syn_sent",
+	"syn_recv",
+	"fin_wait1",
+	"fin_wait2",
+	"time_wait",
+	"close",
+	"close_wait",
+	"last_ack",
+	"listen",
+	"closing",
+	"new_syn_recv",
+}
+
+// NewSockStatStat returns a new Stat, which collects socket stats from
+// /proc/net/sockstat and /proc/net/sockstat6, and the number of TCP
+// connections in each state from /proc/net/tcp and /proc/net/tcp6. The IPv6
+// files are skipped if IPv6 is disabled.
+func NewSockStatStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	reader := &procSockStatCollector{
+		paths:    []string{o.procPath(procSockStatPath)},
+		tcpPaths: []string{o.procPath(procTCPPath)},
+	}
+	if _, err := os.Stat(o.procPath(procSockStat6Path)); err == nil {
+		reader.paths = append(reader.paths, o.procPath(procSockStat6Path))
+	}
+	if _, err := os.Stat(o.procPath(procTCP6Path)); err == nil {
+		reader.tcpPaths = append(reader.tcpPaths, o.procPath(procTCP6Path))
+	}
+	stat := &Stat{Collector: reader}
+	for _, path := range reader.paths {
+		sockStats, err := readSockStat(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, sockStat := range sockStats {
+			reader.keys = append(reader.keys, sockStat.key)
+			stat.Names = append(stat.Names, fmt.Sprintf("sockstat.%s", sockStat.key))
+			stat.Descriptions = append(stat.Descriptions, sockStatDescription(sockStat.key))
+			stat.Units = append(stat.Units, sockStatUnit(sockStat.key))
+			stat.Sources = append(stat.Sources, path)
+		}
+	}
+	if _, err := readTCPStates(reader.tcpPaths); err != nil {
+		return nil, err
+	}
+	for _, state := range tcpStates[1:] {
+		stat.Names = append(stat.Names, fmt.Sprintf("sockstat.tcp.state.%s", state))
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("sockstat.tcp.state.%s = Number of TCP connections in %s state", state, strings.ToUpper(state)))
+		stat.Units = append(stat.Units, Count)
+		stat.Sources = append(stat.Sources, strings.Join(reader.tcpPaths, ","))
+	}
+	stat.Kinds = kinds(Gauge, len(stat.Names))
+	return stat, nil
+}
+
+func (reader *procSockStatCollector) Collect() ([]float64, error) {
+	var sockStats []keyValue
+	for _, path := range reader.paths {
+		values, err := readSockStat(path)
+		if err != nil {
+			return nil, err
+		}
+		sockStats = append(sockStats, values...)
+	}
+	current := keyValueMap(sockStats)
+	var values []float64
+	for _, key := range reader.keys {
+		value, ok := current[key]
+		if !ok {
+			values = append(values, math.NaN())
+			continue
+		}
+		values = append(values, float64(value))
+	}
+	states, err := readTCPStates(reader.tcpPaths)
+	if err != nil {
+		return nil, err
+	}
+	for _, count := range states[1:] {
+		values = append(values, float64(count))
+	}
+	return values, nil
+}
+
+var sockStatDescriptions = map[string]string{
+	"sockets.used": "Number of sockets in use",
+	"tcp.inuse":    "Number of TCP sockets in use",
+	"tcp.orphan":   "Number of orphaned TCP sockets",
+	"tcp.tw":       "Number of TCP sockets in TIME_WAIT state",
+	"tcp.alloc":    "Number of allocated TCP sockets",
+	"tcp.mem":      "Memory used by TCP sockets",
+	"udp.inuse":    "Number of UDP sockets in use",
+	"udp.mem":      "Memory used by UDP sockets",
+	"frag.inuse":   "Number of IP fragment queues in use",
+	"frag.memory":  "Memory used by IP fragment queues",
+}
+
+func sockStatDescription(key string) string {
+	description, ok := sockStatDescriptions[key]
+	if !ok {
+		description = key
+	}
+	switch sockStatUnit(key) {
+	case Pages:
+		description += " (pages)"
+	case Bytes:
+		description += " (bytes)"
+	}
+	return fmt.Sprintf("sockstat.%s = %s", key, description)
+}
+
+// sockStatUnit returns the unit of a socket stat. Socket memory is reported
+// in pages, but fragment queue memory in bytes.
+func sockStatUnit(key string) Unit {
+	switch {
+	case strings.HasSuffix(key, ".mem"):
+		return Pages
+	case strings.HasSuffix(key, ".memory"):
+		return Bytes
+	}
+	return Count
+}
+
+// readSockStat reads a file, which has a protocol followed by pairs of names
+// and values on each line, such as:
+//
+//	TCP: inuse 3 orphan 0 tw 0 alloc 5 mem 1
+//
+// The keys are the lower-case protocol and the name, such as "tcp.inuse".
+func readSockStat(path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields)%2 != 1 {
+			return nil, fmt.Errorf("%s: invalid line '%s'", path, line)
+		}
+		protocol := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+		for idx := 1; idx < len(fields); idx += 2 {
+			key := fmt.Sprintf("%s.%s", protocol, fields[idx])
+			value, err := strconv.ParseUint(fields[idx+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %v", path, key, err)
+			}
+			values = append(values, keyValue{key: key, value: value})
+		}
+	}
+	return values, nil
+}
+
+// readTCPStates returns the number of TCP connections in each state, indexed
+// by the state number, in /proc/net/tcp and /proc/net/tcp6. The state is the
+// fourth field of each line, in hexadecimal.
+func readTCPStates(paths []string) ([]uint64, error) {
+	states := make([]uint64, len(tcpStates))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Scan()
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+			state, err := strconv.ParseUint(fields[3], 16, 8)
+			if err != nil || state >= uint64(len(states)) {
+				continue
+			}
+			states[state]++
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
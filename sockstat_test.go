@@ -0,0 +1,57 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSockStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "net", "sockstat"), `sockets: used 10
+TCP: inuse 3 orphan 0 tw 1 alloc 5 mem 2
+FRAG: inuse 0 memory 0
+`)
+	tcp := filepath.Join(dir, "net", "tcp")
+	writeFile(t, tcp, `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1000 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0016 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 20 4 30 10 -1
+`)
+	stat, err := NewSockStatStat(WithProcRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"sockstat.sockets.used", "sockstat.tcp.inuse", "sockstat.tcp.orphan", "sockstat.tcp.tw", "sockstat.tcp.alloc", "sockstat.tcp.mem", "sockstat.frag.inuse", "sockstat.frag.memory"}
+	for _, state := range tcpStates[1:] {
+		names = append(names, "sockstat.tcp.state."+state)
+	}
+	if !reflect.DeepEqual(stat.Names, names) {
+		t.Fatalf("Names = %v, want %v", stat.Names, names)
+	}
+	if len(stat.Descriptions) != len(names) || len(stat.Kinds) != len(names) || len(stat.Units) != len(names) || len(stat.Sources) != len(names) {
+		t.Fatalf("len(Descriptions), len(Kinds), len(Units), len(Sources) = %d, %d, %d, %d, want %d", len(stat.Descriptions), len(stat.Kinds), len(stat.Units), len(stat.Sources), len(names))
+	}
+	if stat.Kinds[0] != Gauge || stat.Units[5] != Pages || stat.Units[7] != Bytes || stat.Units[8] != Count {
+		t.Errorf("Kinds = %v, Units = %v", stat.Kinds, stat.Units)
+	}
+	writeFile(t, tcp, `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1000 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0016 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:0016 0100007F:C351 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 20 4 30 10 -1
+   3: 0100007F:0016 0100007F:C352 06 00000000:00000000 00:00000000 00000000     0        0 0 1 0000000000000000 20 4 30 10 -1
+`)
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{10, 3, 0, 1, 5, 2, 0, 0, 2, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Collect() = %v, want %v", values, wantValues)
+	}
+}
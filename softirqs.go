@@ -2,68 +2,91 @@ package ustat
 
 import (
 	"fmt"
-	procfs "github.com/c9s/goprocinfo/linux"
 )
 
 type procSoftIRQsCollector struct {
-	counts []uint64
+	path       string
+	opts       *options
+	interrupts []interrupt
+	names      []string
+	counters   *counterSet
 }
 
-const procSoftIRQsPath = "/proc/softirqs"
+const procSoftIRQsPath = "softirqs"
 
 // NewSoftIRQsStat returns a new Stat, which collects interrupt stats from /proc/interrupts.
-func NewSoftIRQsStat() *Stat {
-	interrupts, err := procfs.ReadInterrupts(procSoftIRQsPath)
+func NewSoftIRQsStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	path := o.procPath(procSoftIRQsPath)
+	interrupts, err := readInterrupts(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	names := parseSoftIRQNames(interrupts)
-	descriptions := parseSoftIRQDescriptions(interrupts)
-	counts := parseSoftIRQCounts(interrupts)
-	return &Stat{
-		Names:        names,
-		Descriptions: descriptions,
-		Collector:    &procSoftIRQsCollector{counts: counts},
+	reader := &procSoftIRQsCollector{
+		path:       path,
+		opts:       o,
+		interrupts: interrupts,
+		names:      names,
+		counters:   newCounterSet(names, parseSoftIRQCounts(interrupts), o),
 	}
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
 }
 
-func (reader *procSoftIRQsCollector) Collect() []uint64 {
-	interrupts, err := procfs.ReadInterrupts(procSoftIRQsPath)
+func (reader *procSoftIRQsCollector) Collect() ([]float64, error) {
+	interrupts, err := readInterrupts(reader.path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	counts := parseSoftIRQCounts(interrupts)
-	diff := Difference(reader.counts, counts)
-	reader.counts = counts
-	return diff
+	reader.interrupts = interrupts
+	return reader.counters.update(reader.names, parseSoftIRQNames(interrupts), parseSoftIRQCounts(interrupts)), nil
 }
 
-func parseSoftIRQNames(interrupts *procfs.Interrupts) []string {
+// UpdateColumns updates stat to match the softirqs in the last sample.
+func (reader *procSoftIRQsCollector) UpdateColumns(stat *Stat) bool {
+	names := parseSoftIRQNames(reader.interrupts)
+	if equalStrings(names, reader.names) {
+		return false
+	}
+	reader.names = names
+	reader.describe(stat)
+	return true
+}
+
+func (reader *procSoftIRQsCollector) describe(stat *Stat) {
+	stat.Names = reader.names
+	stat.Descriptions = parseSoftIRQDescriptions(reader.interrupts, reader.opts)
+	stat.Kinds = kinds(Counter, len(reader.names))
+	stat.Units = units(reader.opts.counterUnit(Count), len(reader.names))
+	stat.Sources = sources(reader.path, len(reader.names))
+}
+
+func parseSoftIRQNames(interrupts []interrupt) []string {
 	var names []string
-	for _, interrupt := range interrupts.Interrupts {
-		for cpu := range interrupt.Counts {
-			name := fmt.Sprintf("softirq.%s.cpu%d", interrupt.Name, cpu)
+	for _, interrupt := range interrupts {
+		for _, cpu := range interrupt.cpus {
+			name := fmt.Sprintf("softirq.%s.cpu%d", interrupt.name, cpu)
 			names = append(names, name)
 		}
 	}
 	return names
 }
 
-func parseSoftIRQDescriptions(interrupts *procfs.Interrupts) []string {
+func parseSoftIRQDescriptions(interrupts []interrupt, o *options) []string {
 	var descriptions []string
-	for _, interrupt := range interrupts.Interrupts {
-		description := fmt.Sprintf("softirq.%s = %s", interrupt.Name, interrupt.Description)
+	for _, interrupt := range interrupts {
+		description := fmt.Sprintf("softirq.%s = %s (%s)", interrupt.name, interrupt.description, o.unit("softirqs"))
 		descriptions = append(descriptions, description)
 	}
 	return descriptions
 }
 
-func parseSoftIRQCounts(interrupts *procfs.Interrupts) []uint64 {
+func parseSoftIRQCounts(interrupts []interrupt) []uint64 {
 	var values []uint64
-	for _, interrupt := range interrupts.Interrupts {
-		for _, count := range interrupt.Counts {
-			values = append(values, count)
-		}
+	for _, interrupt := range interrupts {
+		values = append(values, interrupt.counts...)
 	}
 	return values
 }
@@ -1,22 +1,253 @@
 package ustat
 
-// A Stat is a collection of named stats.
+import (
+	"math"
+	"time"
+)
+
+// A Stat is a collection of named stats. Kinds, Units and Sources describe
+// the column with the same index in Names.
 type Stat struct {
 	Names        []string
 	Descriptions []string
+	Kinds        []Kind
+	Units        []Unit
+	Sources      []string
 	Collector    StatCollector
 }
 
-// A StatCollector is an interface for collecting stats.
+// A Kind describes how the values of a column are derived.
+type Kind int
+
+const (
+	// Counter columns report the change in a cumulative counter, such as
+	// the number of bytes received, between samples.
+	Counter Kind = iota
+	// Gauge columns report a level, such as the amount of free memory, as
+	// is. Gauges must not be differenced.
+	Gauge
+	// Ratio columns report the change in one counter relative to another
+	// between samples, such as CPU utilization.
+	Ratio
+)
+
+func (kind Kind) String() string {
+	switch kind {
+	case Counter:
+		return "counter"
+	case Gauge:
+		return "gauge"
+	case Ratio:
+		return "ratio"
+	}
+	return "unknown"
+}
+
+// A Unit is the unit of the values of a column. The unit of counters that
+// are normalized to a rate is the unit per second, such as "bytes/s".
+type Unit string
+
+const (
+	Bytes        Unit = "bytes"
+	Kilobytes    Unit = "kB"
+	Sectors      Unit = "sectors"
+	Pages        Unit = "pages"
+	Jiffies      Unit = "jiffies"
+	Nanoseconds  Unit = "ns"
+	Microseconds Unit = "us"
+	Milliseconds Unit = "ms"
+	Kilohertz    Unit = "kHz"
+	Celsius      Unit = "celsius"
+	RPM          Unit = "rpm"
+	Volts        Unit = "volts"
+	Watts        Unit = "watts"
+	Percent      Unit = "percent"
+	Count        Unit = "count"
+)
+
+// A StatCollector is an interface for collecting stats. Values that could
+// not be collected, for example because a counter was reset, are NaN.
 type StatCollector interface {
-	Collect() []uint64
+	Collect() ([]float64, error)
+}
+
+// A ColumnUpdater is a StatCollector whose columns can change during a
+// recording, for example when a CPU is hot-added or a network interface is
+// created. Collect matches entities such as CPUs, devices and interrupts by
+// identity and reports NaN for entities that have disappeared. UpdateColumns
+// then updates the columns of stat to match the entities in the last sample
+// and reports whether they changed.
+type ColumnUpdater interface {
+	StatCollector
+	UpdateColumns(stat *Stat) bool
 }
 
-// Difference calculates the change in values for two arrays.
-func Difference(before []uint64, after []uint64) []uint64 {
-	var diff []uint64
+// Difference calculates the change in values for two arrays of counters that
+// are width bits wide. The arrays must have the same layout; the change is
+// NaN for counters that are missing from after. A counter that is smaller
+// than before has either wrapped around or been reset, for example because a
+// driver was reloaded. The counter is considered to have wrapped around if
+// the change modulo 2^width is less than half of the counter range.
+// Otherwise the counter is considered to have been reset and the change is
+// NaN, as it is if either value is wider than width.
+func Difference(before []uint64, after []uint64, width uint) []float64 {
+	var diff []float64
 	for idx := range before {
-		diff = append(diff, after[idx]-before[idx])
+		if idx >= len(after) {
+			diff = append(diff, math.NaN())
+			continue
+		}
+		diff = append(diff, counterDifference(before[idx], after[idx], width))
+	}
+	return diff
+}
+
+func counterDifference(before uint64, after uint64, width uint) float64 {
+	if after >= before {
+		return float64(after - before)
+	}
+	if width < 64 && (before>>width != 0 || after>>width != 0) {
+		return math.NaN()
+	}
+	delta := after - before
+	if width < 64 {
+		delta &= 1<<width - 1
+	}
+	if delta >= 1<<(width-1) {
+		return math.NaN()
+	}
+	return float64(delta)
+}
+
+// Rate calculates the change per second for an array of changes in values
+// that happened during elapsed time. If no time has elapsed, the rates are
+// NaN.
+func Rate(diff []float64, elapsed time.Duration) []float64 {
+	var rate []float64
+	for _, value := range diff {
+		if elapsed <= 0 {
+			rate = append(rate, math.NaN())
+			continue
+		}
+		rate = append(rate, value/elapsed.Seconds())
+	}
+	return rate
+}
+
+// A counterSet tracks the previous values of named cumulative counters, such
+// as the number of bytes received on a network interface.
+type counterSet struct {
+	values map[string]uint64
+	time   time.Time
+	width  uint
+	rate   bool
+}
+
+func newCounterSet(names []string, values []uint64, opts *options) *counterSet {
+	return &counterSet{
+		values: counterMap(names, values),
+		time:   time.Now(),
+		width:  opts.counterWidth,
+		rate:   opts.rate,
+	}
+}
+
+// update returns the change in the counters named by columns since the
+// previous update. Counters are matched by name rather than by position, and
+// the change is NaN for counters that are missing from either update. If rate
+// normalization is enabled, the change is divided by the time elapsed between
+// the updates, as measured by the monotonic clock.
+func (counters *counterSet) update(columns []string, names []string, values []uint64) []float64 {
+	deltas, elapsed := counters.deltas(names, values)
+	var diff []float64
+	for _, column := range columns {
+		diff = append(diff, counters.normalize(deltas.value(column), elapsed))
 	}
 	return diff
 }
+
+// A counterDeltas has the change in counters between two updates of a
+// counterSet, keyed by counter name.
+type counterDeltas map[string]float64
+
+// value returns the change in the named counter, or NaN if the counter is
+// missing from either update.
+func (deltas counterDeltas) value(name string) float64 {
+	delta, ok := deltas[name]
+	if !ok {
+		return math.NaN()
+	}
+	return delta
+}
+
+// deltas returns the change in the counters since the previous update and
+// the time elapsed between the updates, without rate normalization. It is
+// used by collectors that derive stats, such as averages, from the changes
+// in several counters.
+func (counters *counterSet) deltas(names []string, values []uint64) (counterDeltas, time.Duration) {
+	now := time.Now()
+	curr := counterMap(names, values)
+	deltas := counterDeltas{}
+	for name, after := range curr {
+		if before, ok := counters.values[name]; ok {
+			deltas[name] = counterDifference(before, after, counters.width)
+		}
+	}
+	elapsed := now.Sub(counters.time)
+	counters.values = curr
+	counters.time = now
+	return deltas, elapsed
+}
+
+// normalize returns the change in a counter per second if rate normalization
+// is enabled, and the change as is otherwise.
+func (counters *counterSet) normalize(delta float64, elapsed time.Duration) float64 {
+	if counters.rate {
+		return Rate([]float64{delta}, elapsed)[0]
+	}
+	return delta
+}
+
+func counterMap(names []string, values []uint64) map[string]uint64 {
+	counters := make(map[string]uint64, len(names))
+	for idx, name := range names {
+		counters[name] = values[idx]
+	}
+	return counters
+}
+
+func kinds(kind Kind, count int) []Kind {
+	var result []Kind
+	for i := 0; i < count; i++ {
+		result = append(result, kind)
+	}
+	return result
+}
+
+func units(unit Unit, count int) []Unit {
+	var result []Unit
+	for i := 0; i < count; i++ {
+		result = append(result, unit)
+	}
+	return result
+}
+
+func sources(source string, count int) []string {
+	var result []string
+	for i := 0; i < count; i++ {
+		result = append(result, source)
+	}
+	return result
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package ustat
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounterSetDeltas(t *testing.T) {
+	counters := newCounterSet([]string{"a", "b"}, []uint64{10, 20}, &options{counterWidth: 64, rate: true})
+	deltas, elapsed := counters.deltas([]string{"a", "c"}, []uint64{15, 30})
+	if elapsed <= 0 {
+		t.Errorf("elapsed = %v, want > 0", elapsed)
+	}
+	if got := deltas.value("a"); got != 5 {
+		t.Errorf("delta of a = %v, want 5", got)
+	}
+	for _, name := range []string{"b", "c"} {
+		if got := deltas.value(name); !math.IsNaN(got) {
+			t.Errorf("delta of %s = %v, want NaN", name, got)
+		}
+	}
+	if got := counters.normalize(5, 2*time.Second); got != 2.5 {
+		t.Errorf("normalize(5, 2s) = %v, want 2.5", got)
+	}
+}
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		name   string
+		before uint64
+		after  uint64
+		width  uint
+		want   float64
+	}{
+		{"increase", 100, 150, 64, 50},
+		{"unchanged", 100, 100, 64, 0},
+		{"32-bit wrap", 0xfffffff0, 0x10, 32, 0x20},
+		{"64-bit wrap", math.MaxUint64 - 5, 10, 64, 16},
+		{"64-bit reset", 1000000000000, 5, 64, math.NaN()},
+		{"32-bit reset", 2000000000, 5, 32, math.NaN()},
+		{"reset below width", 1 << 40, 5, 32, math.NaN()},
+	}
+	for _, test := range tests {
+		got := Difference([]uint64{test.before}, []uint64{test.after}, test.width)
+		if len(got) != 1 || !sameValue(got[0], test.want) {
+			t.Errorf("%s: Difference(%d, %d, %d) = %v, want %v", test.name, test.before, test.after, test.width, got, test.want)
+		}
+	}
+}
+
+func TestDifferenceMissingCounter(t *testing.T) {
+	got := Difference([]uint64{1, 2}, []uint64{3}, 64)
+	if len(got) != 2 || got[0] != 2 || !math.IsNaN(got[1]) {
+		t.Errorf("Difference() = %v, want [2 NaN]", got)
+	}
+}
+
+func sameValue(got float64, want float64) bool {
+	if math.IsNaN(want) {
+		return math.IsNaN(got)
+	}
+	return got == want
+}
@@ -0,0 +1,259 @@
+package ustat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type thermalCollector struct {
+	sensors []sensor
+}
+
+// A sensor is a thermal zone temperature or a hwmon sensor input. The value
+// in the input file is divided by scale to convert it to SI units.
+type sensor struct {
+	name        string
+	description string
+	unit        Unit
+	path        string
+	scale       float64
+}
+
+const (
+	sysThermalPath = "class/thermal"
+	sysHwmonPath   = "class/hwmon"
+)
+
+// hwmonSensorTypes are the hwmon sensor types that are collected, in the
+// order in which they are reported for each chip.
+var hwmonSensorTypes = []string{"temp", "fan", "in", "power"}
+
+var hwmonSensorDescriptions = map[string]string{
+	"temp":  "Temperature",
+	"fan":   "Fan speed",
+	"in":    "Voltage",
+	"power": "Power",
+}
+
+var hwmonSensorUnits = map[string]Unit{
+	"temp":  Celsius,
+	"fan":   RPM,
+	"in":    Volts,
+	"power": Watts,
+}
+
+// hwmonSensorScales are the divisors that convert the values of hwmon sensor
+// inputs, which are in millidegrees Celsius, RPM, millivolts and
+// microwatts, to SI units.
+var hwmonSensorScales = map[string]float64{
+	"temp":  1000,
+	"fan":   1,
+	"in":    1000,
+	"power": 1000000,
+}
+
+var hwmonInputPattern = regexp.MustCompile(`^(temp|fan|in|power)([0-9]+)_input$`)
+
+// NewThermalStat returns a new Stat, which collects the temperature of the
+// thermal zones in /sys/class/thermal and the temperature, fan speed,
+// voltage and power sensors of the hardware monitoring chips in
+// /sys/class/hwmon. Thermal zones are named after their type, such as
+// "thermal.x86_pkg_temp.temp", and sensors after their chip, type and label,
+// such as "hwmon.coretemp.temp.Core_0". An error is returned if there are no
+// sensors.
+func NewThermalStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	sensors, err := readThermalZones(o.sysPath(sysThermalPath))
+	if err != nil {
+		return nil, err
+	}
+	hwmonSensors, err := readHwmonSensors(o.sysPath(sysHwmonPath))
+	if err != nil {
+		return nil, err
+	}
+	sensors = append(sensors, hwmonSensors...)
+	if len(sensors) == 0 {
+		return nil, fmt.Errorf("%s, %s: no thermal zones or hwmon sensors", o.sysPath(sysThermalPath), o.sysPath(sysHwmonPath))
+	}
+	stat := &Stat{Collector: &thermalCollector{sensors: sensors}}
+	for _, sensor := range sensors {
+		stat.Names = append(stat.Names, sensor.name)
+		stat.Descriptions = append(stat.Descriptions, fmt.Sprintf("%s = %s (%s)", sensor.name, sensor.description, sensor.unit))
+		stat.Kinds = append(stat.Kinds, Gauge)
+		stat.Units = append(stat.Units, sensor.unit)
+		stat.Sources = append(stat.Sources, sensor.path)
+	}
+	return stat, nil
+}
+
+// Collect returns the current sensor values. Sensors that cannot be read,
+// such as disconnected fans, are reported as NaN.
+func (reader *thermalCollector) Collect() ([]float64, error) {
+	var values []float64
+	for _, sensor := range reader.sensors {
+		data, err := ioutil.ReadFile(sensor.path)
+		if err != nil {
+			values = append(values, math.NaN())
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			values = append(values, math.NaN())
+			continue
+		}
+		values = append(values, float64(value)/sensor.scale)
+	}
+	return values, nil
+}
+
+// readThermalZones returns the temperature sensors of the thermal zones in
+// dir in ascending order of zone number. Zones are named after their type,
+// or after the zone directory if the type is missing or is not unique. It
+// returns no sensors if dir does not exist.
+func readThermalZones(dir string) ([]sensor, error) {
+	ids, err := readNumberedEntries(dir, "thermal_zone")
+	if err != nil {
+		return nil, err
+	}
+	var sensors []sensor
+	seen := map[string]bool{}
+	for _, id := range ids {
+		zone := fmt.Sprintf("thermal_zone%d", id)
+		path := filepath.Join(dir, zone, "temp")
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		name := readSysfsName(filepath.Join(dir, zone, "type"))
+		if name == "" || seen[name] {
+			name = zone
+		}
+		seen[name] = true
+		sensors = append(sensors, sensor{
+			name:        fmt.Sprintf("thermal.%s.temp", name),
+			description: fmt.Sprintf("Temperature of thermal zone %d", id),
+			unit:        Celsius,
+			path:        path,
+			scale:       1000,
+		})
+	}
+	return sensors, nil
+}
+
+// readHwmonSensors returns the sensor inputs of the hwmon chips in dir in
+// ascending order of chip number. Chips are named after their name file, or
+// after the chip directory if the name is missing or is not unique, and
+// sensors after their label file, or after their number if the label is
+// missing or is not unique. It returns no sensors if dir does not exist.
+func readHwmonSensors(dir string) ([]sensor, error) {
+	ids, err := readNumberedEntries(dir, "hwmon")
+	if err != nil {
+		return nil, err
+	}
+	var sensors []sensor
+	seen := map[string]bool{}
+	for _, id := range ids {
+		chipDir := filepath.Join(dir, fmt.Sprintf("hwmon%d", id))
+		// Drivers before Linux 3.15 have the attributes in the device
+		// directory of the chip.
+		if _, err := os.Stat(filepath.Join(chipDir, "name")); err != nil {
+			chipDir = filepath.Join(chipDir, "device")
+		}
+		chip := readSysfsName(filepath.Join(chipDir, "name"))
+		if chip == "" || seen[chip] {
+			chip = fmt.Sprintf("hwmon%d", id)
+		}
+		seen[chip] = true
+		chipSensors, err := readHwmonChip(chipDir, chip)
+		if err != nil {
+			return nil, err
+		}
+		sensors = append(sensors, chipSensors...)
+	}
+	return sensors, nil
+}
+
+func readHwmonChip(dir string, chip string) ([]sensor, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inputs := map[string][]int{}
+	for _, entry := range entries {
+		match := hwmonInputPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		index, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		inputs[match[1]] = append(inputs[match[1]], index)
+	}
+	var sensors []sensor
+	for _, sensorType := range hwmonSensorTypes {
+		indexes := inputs[sensorType]
+		sort.Ints(indexes)
+		seen := map[string]bool{}
+		for _, index := range indexes {
+			label := readSysfsName(filepath.Join(dir, fmt.Sprintf("%s%d_label", sensorType, index)))
+			if label == "" || seen[label] {
+				label = strconv.Itoa(index)
+			}
+			seen[label] = true
+			sensors = append(sensors, sensor{
+				name:        fmt.Sprintf("hwmon.%s.%s.%s", chip, sensorType, label),
+				description: fmt.Sprintf("%s of %s sensor %s%d", hwmonSensorDescriptions[sensorType], chip, sensorType, index),
+				unit:        hwmonSensorUnits[sensorType],
+				path:        filepath.Join(dir, fmt.Sprintf("%s%d_input", sensorType, index)),
+				scale:       hwmonSensorScales[sensorType],
+			})
+		}
+	}
+	return sensors, nil
+}
+
+// readNumberedEntries returns the numbers of the entries of dir that consist
+// of prefix and a number, such as "hwmon0", in ascending order. It returns
+// no numbers if dir does not exist.
+func readNumberedEntries(dir string, prefix string) ([]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), prefix))
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// readSysfsName reads a name or a label from a sysfs file and replaces the
+// whitespace in it with underscores, so that it can be used in a column
+// name. It returns an empty string if the file cannot be read.
+func readSysfsName(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(string(data)), "_")
+}
@@ -0,0 +1,96 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestThermalSensors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	thermal := filepath.Join(dir, "class", "thermal")
+	writeFile(t, filepath.Join(thermal, "thermal_zone0", "type"), "acpitz\n")
+	writeFile(t, filepath.Join(thermal, "thermal_zone0", "temp"), "27800\n")
+	writeFile(t, filepath.Join(thermal, "thermal_zone1", "type"), "acpitz\n")
+	writeFile(t, filepath.Join(thermal, "thermal_zone1", "temp"), "-5000\n")
+	writeFile(t, filepath.Join(thermal, "thermal_zone10", "type"), "x86_pkg_temp\n")
+	writeFile(t, filepath.Join(thermal, "thermal_zone10", "temp"), "55000\n")
+	writeFile(t, filepath.Join(thermal, "cooling_device0", "type"), "Processor\n")
+	hwmon := filepath.Join(dir, "class", "hwmon")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "name"), "coretemp\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp1_label"), "Package id 0\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp1_input"), "45000\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp1_crit"), "100000\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp2_label"), "Core 0\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp2_input"), "44000\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp3_label"), "Core 0\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp3_input"), "43000\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon0", "temp10_input"), "42000\n")
+	// Drivers before Linux 3.15 have the attributes in the device directory.
+	writeFile(t, filepath.Join(hwmon, "hwmon1", "device", "name"), "nct6775\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon1", "device", "fan2_input"), "1200\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon1", "device", "in0_input"), "1136\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon1", "device", "power1_input"), "12500000\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon2", "name"), "coretemp\n")
+	writeFile(t, filepath.Join(hwmon, "hwmon2", "temp1_input"), "1000\n")
+	stat, err := NewThermalStat(WithSysRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"thermal.acpitz.temp",
+		"thermal.thermal_zone1.temp",
+		"thermal.x86_pkg_temp.temp",
+		"hwmon.coretemp.temp.Package_id_0",
+		"hwmon.coretemp.temp.Core_0",
+		"hwmon.coretemp.temp.3",
+		"hwmon.coretemp.temp.10",
+		"hwmon.nct6775.fan.2",
+		"hwmon.nct6775.in.0",
+		"hwmon.nct6775.power.1",
+		"hwmon.hwmon2.temp.1",
+	}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	wantUnits := []Unit{Celsius, Celsius, Celsius, Celsius, Celsius, Celsius, Celsius, RPM, Volts, Watts, Celsius}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{27.8, -5, 55, 45, 44, 43, 42, 1200, 1.136, 12.5, 1}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Collect() = %v, want %v", values, wantValues)
+	}
+	if err := os.Remove(filepath.Join(hwmon, "hwmon1", "device", "fan2_input")); err != nil {
+		t.Fatal(err)
+	}
+	values, err = stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(values[7]) {
+		t.Errorf("Collect()[7] = %v, want NaN", values[7])
+	}
+}
+
+func TestThermalNoSensors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := NewThermalStat(WithSysRoot(dir)); err == nil {
+		t.Error("NewThermalStat() succeeded without sensors, want error")
+	}
+}
@@ -0,0 +1,160 @@
+package ustat
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type procVMStatCollector struct {
+	path     string
+	opts     *options
+	stats    []keyValue
+	keys     []string
+	counters *counterSet
+}
+
+const procVMStatPath = "vmstat"
+
+// NewVMStat returns a new Stat, which collects virtual memory stats from
+// /proc/vmstat. The keys are discovered from the running kernel rather than
+// taken from a fixed list, because they vary between kernel versions and
+// configurations.
+func NewVMStat(opts ...Option) (*Stat, error) {
+	o := newOptions(opts)
+	path := o.procPath(procVMStatPath)
+	stats, err := readKeyValues(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := keyValueKeys(stats)
+	reader := &procVMStatCollector{
+		path:     path,
+		opts:     o,
+		stats:    stats,
+		keys:     keys,
+		counters: newCounterSet(keys, keyValueValues(stats), o),
+	}
+	stat := &Stat{Collector: reader}
+	reader.describe(stat)
+	return stat, nil
+}
+
+func (reader *procVMStatCollector) Collect() ([]float64, error) {
+	stats, err := readKeyValues(reader.path)
+	if err != nil {
+		return nil, err
+	}
+	reader.stats = stats
+	values := reader.counters.update(reader.keys, keyValueKeys(stats), keyValueValues(stats))
+	current := keyValueMap(stats)
+	for idx, key := range reader.keys {
+		if vmStatKind(key) != Gauge {
+			continue
+		}
+		value, ok := current[key]
+		if !ok {
+			values[idx] = math.NaN()
+			continue
+		}
+		values[idx] = float64(value)
+	}
+	return values, nil
+}
+
+// UpdateColumns updates stat to match the keys in the last sample.
+func (reader *procVMStatCollector) UpdateColumns(stat *Stat) bool {
+	keys := keyValueKeys(reader.stats)
+	if equalStrings(keys, reader.keys) {
+		return false
+	}
+	reader.keys = keys
+	reader.describe(stat)
+	return true
+}
+
+func (reader *procVMStatCollector) describe(stat *Stat) {
+	stat.Names = nil
+	stat.Descriptions = nil
+	stat.Kinds = nil
+	stat.Units = nil
+	for _, key := range reader.keys {
+		kind := vmStatKind(key)
+		stat.Names = append(stat.Names, fmt.Sprintf("vmstat.%s", key))
+		stat.Descriptions = append(stat.Descriptions, vmStatDescription(key, reader.opts))
+		stat.Kinds = append(stat.Kinds, kind)
+		if kind == Gauge {
+			stat.Units = append(stat.Units, Pages)
+		} else {
+			stat.Units = append(stat.Units, reader.opts.counterUnit(Count))
+		}
+	}
+	stat.Sources = sources(reader.path, len(reader.keys))
+}
+
+var vmStatDescriptions = map[string]string{
+	"nr_free_pages":      "Free pages",
+	"nr_dirty":           "Dirty pages",
+	"nr_writeback":       "Pages under writeback",
+	"nr_dirtied":         "Number of pages dirtied",
+	"nr_written":         "Number of pages written back",
+	"pgpgin":             "Number of kilobytes paged in from disk",
+	"pgpgout":            "Number of kilobytes paged out to disk",
+	"pswpin":             "Number of pages swapped in",
+	"pswpout":            "Number of pages swapped out",
+	"pgfault":            "Number of page faults",
+	"pgmajfault":         "Number of major page faults",
+	"pgfree":             "Number of pages freed",
+	"pgactivate":         "Number of pages activated",
+	"pgdeactivate":       "Number of pages deactivated",
+	"pgscan_kswapd":      "Number of pages scanned by kswapd",
+	"pgscan_direct":      "Number of pages scanned by direct reclaim",
+	"pgsteal_kswapd":     "Number of pages reclaimed by kswapd",
+	"pgsteal_direct":     "Number of pages reclaimed by direct reclaim",
+	"allocstall":         "Number of direct reclaim stalls",
+	"compact_stall":      "Number of direct compaction stalls",
+	"compact_fail":       "Number of failed direct compactions",
+	"compact_success":    "Number of successful direct compactions",
+	"thp_fault_alloc":    "Number of transparent huge pages allocated on page fault",
+	"thp_collapse_alloc": "Number of transparent huge pages allocated by khugepaged",
+	"numa_hit":           "Number of allocations on the intended node",
+	"numa_miss":          "Number of allocations on another node than intended",
+	"numa_foreign":       "Number of allocations intended for this node but made on another",
+	"numa_local":         "Number of allocations on the local node",
+	"numa_other":         "Number of allocations on a remote node",
+	"oom_kill":           "Number of processes killed by the OOM killer",
+}
+
+// vmStatCounters are keys with the "nr_" prefix that are cumulative counters
+// rather than numbers of pages.
+var vmStatCounters = map[string]bool{
+	"nr_dirtied":                  true,
+	"nr_written":                  true,
+	"nr_throttled_written":        true,
+	"nr_vmscan_write":             true,
+	"nr_vmscan_immediate_reclaim": true,
+	"nr_foll_pin_acquired":        true,
+	"nr_foll_pin_released":        true,
+}
+
+// vmStatKind returns the kind of a /proc/vmstat key. Keys with the "nr_"
+// prefix report the current number of pages in some state; the other keys
+// are cumulative event counters.
+func vmStatKind(key string) Kind {
+	if strings.HasPrefix(key, "nr_") && !vmStatCounters[key] {
+		return Gauge
+	}
+	return Counter
+}
+
+func vmStatDescription(key string, o *options) string {
+	description, ok := vmStatDescriptions[key]
+	if !ok {
+		description = key
+	}
+	unit := "pages"
+	if vmStatKind(key) == Counter {
+		unit = o.unit("events")
+	}
+	return fmt.Sprintf("vmstat.%s = %s (%s)", key, description, unit)
+}
@@ -0,0 +1,44 @@
+package ustat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVMStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ustat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vmstat")
+	writeFile(t, path, "nr_free_pages 1000\nnr_dirtied 50\npgfault 100\n")
+	stat, err := NewVMStat(WithProcRoot(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"vmstat.nr_free_pages", "vmstat.nr_dirtied", "vmstat.pgfault"}
+	if !reflect.DeepEqual(stat.Names, want) {
+		t.Fatalf("Names = %v, want %v", stat.Names, want)
+	}
+	wantKinds := []Kind{Gauge, Counter, Counter}
+	if !reflect.DeepEqual(stat.Kinds, wantKinds) {
+		t.Errorf("Kinds = %v, want %v", stat.Kinds, wantKinds)
+	}
+	wantUnits := []Unit{Pages, Count, Count}
+	if !reflect.DeepEqual(stat.Units, wantUnits) {
+		t.Errorf("Units = %v, want %v", stat.Units, wantUnits)
+	}
+	writeFile(t, path, "nr_free_pages 900\nnr_dirtied 60\npgfault 130\n")
+	values, err := stat.Collector.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantValues := []float64{900, 10, 30}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Collect() = %v, want %v", values, wantValues)
+	}
+}